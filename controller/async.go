@@ -0,0 +1,26 @@
+package controller
+
+// CommandResult is the outcome of a command started with CommandExecAsync.
+type CommandResult struct {
+	Response []byte
+	Err      error
+}
+
+// CommandExecAsync sends a command to the device without blocking the caller. The command is
+// queued with PriorityInteractive and the default timeout. Exactly one result is delivered on the
+// returned channel, which is buffered, so the caller does not need to read it. The error is
+// ErrorTimeout if the device did not answer, ErrorClosed if the device is gone, or any other
+// error returned by CommandExecOptions. The payload is copied before returning.
+func (d *BusDevice) CommandExecAsync(payload []byte) <-chan CommandResult {
+	result := make(chan (CommandResult), 1)
+	payload = append([]byte(nil), payload...)
+
+	go func() {
+		resp, err := d.CommandExecOptions(&CommandOptions{
+			Priority: PriorityInteractive,
+		}, payload, nil)
+		result <- CommandResult{Response: resp, Err: err}
+	}()
+
+	return result
+}
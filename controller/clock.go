@@ -0,0 +1,27 @@
+package controller
+
+import "time"
+
+// Clock is the source of time for the scheduling, scanning and eviction logic of the controller,
+// including the foreign master holdoff, the idle time inserted for MaxUtilization and the access
+// deadline. Replace it to run the controller deterministically in tests or simulations. Command
+// timeouts and bus timing, which depend on the PHY, always use the system clock.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
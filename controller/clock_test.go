@@ -0,0 +1,126 @@
+package controller_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/controller"
+)
+
+/* manualClock only advances when Advance is called */
+type manualClock struct {
+	sync.Mutex
+
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	deadline time.Time
+	c        chan (time.Time)
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{
+		now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (m *manualClock) Now() time.Time {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.now
+}
+
+func (m *manualClock) Sleep(d time.Duration) {
+	<-m.After(d)
+}
+
+func (m *manualClock) After(d time.Duration) <-chan time.Time {
+	m.Lock()
+	defer m.Unlock()
+
+	c := make(chan (time.Time), 1)
+	if d <= 0 {
+		c <- m.now
+		return c
+	}
+
+	m.waiters = append(m.waiters, manualWaiter{deadline: m.now.Add(d), c: c})
+	return c
+}
+
+func (m *manualClock) Advance(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.now = m.now.Add(d)
+
+	waiters := m.waiters[:0]
+	for _, w := range m.waiters {
+		if w.deadline.After(m.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.c <- m.now
+		}
+	}
+	m.waiters = waiters
+}
+
+func TestEnumerateWithManualClock(t *testing.T) {
+	serial := []byte("9876543210")
+	p, sim := newSimulatedDevice(t, serial)
+
+	clock := newManualClock()
+	c := controller.NewWithConfig(p, 1, func(device *controller.BusDevice) controller.FunctionalDevice {
+		return &serialReader{device: device}
+	}, &controller.Config{
+		Clock: clock,
+	})
+	c.PollInterval = time.Minute
+
+	result := make(chan (error), 1)
+	go func() {
+		result <- c.Run()
+	}()
+
+	/* Enumeration and the first poll do not need the clock to advance */
+	if !waitFor(t, 5*time.Second, func() bool { _, reads := sim.state(); return reads >= 1 }) {
+		t.Fatal("Device was not enumerated and polled")
+	}
+	if c.DeviceBySerial(serial) == nil {
+		t.Fatal("Device is not known to the controller")
+	}
+
+	/* The next poll is only due when the clock reached the poll interval */
+	time.Sleep(200 * time.Millisecond)
+	if _, reads := sim.state(); reads != 1 {
+		t.Fatalf("Device was polled %d times before the poll interval passed", reads)
+	}
+
+	/* Advance in small steps, Run may register its sleep just after a step */
+	clock.Advance(time.Minute)
+	if !waitFor(t, 5*time.Second, func() bool {
+		clock.Advance(100 * time.Millisecond)
+		_, reads := sim.state()
+		return reads >= 2
+	}) {
+		t.Fatal("Device was not polled after the poll interval passed")
+	}
+
+	/* Run may be sleeping on the clock, keep advancing until it notices the close */
+	c.Close()
+	deadline := time.After(5 * time.Second)
+	for {
+		clock.Advance(100 * time.Millisecond)
+		select {
+		case <-result:
+			return
+		case <-deadline:
+			t.Fatal("Run did not return after Close")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
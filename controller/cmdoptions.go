@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// CommandOptions controls how CommandExecOptions sends a command and checks the response.
+type CommandOptions struct {
+	// Timeout is the time to wait for each attempt. When 0, the CommandTimeout of the controller
+	// Config is used.
+	Timeout time.Duration
+
+	// Priority is the priority of the command in the queue.
+	Priority CommandPriority
+
+	// ExpectReply requires the first byte of the response to be the opcode of the command plus
+	// one, which is how devices answer most commands.
+	ExpectReply bool
+
+	// Validate is an optional function that checks the response.
+	Validate func(response []byte) bool
+
+	// ResponseAddresses are source addresses that are accepted for the response, in addition to
+	// the address of the device. Use it for devices that answer some commands from another
+	// address, for example 0.
+	ResponseAddresses []uint8
+
+	// Retries is the number of times the command is sent again after a timeout or an invalid
+	// response.
+	Retries int
+}
+
+func (o *CommandOptions) valid(payload []byte, response []byte) bool {
+	if o.ExpectReply && (len(payload) == 0 || len(response) == 0 || response[0] != payload[0]+1) {
+		return false
+	}
+	if o.Validate != nil && !o.Validate(response) {
+		return false
+	}
+	return true
+}
+
+// CommandExecOptions sends a command to the device and checks the response as described by the
+// options. It returns ErrorTimeout if the device did not answer and ErrorBadResponse if the
+// response did not pass validation, after all retries were used.
+func (d *BusDevice) CommandExecOptions(options *CommandOptions, payload []byte, response []byte) ([]byte, error) {
+	if options == nil {
+		options = &CommandOptions{}
+	}
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = d.controller.config.CommandTimeout
+	}
+
+	var err error
+	for attempt := 0; attempt <= options.Retries; attempt++ {
+		var resp []byte
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resp, err = d.commandExecFrom(ctx, options.Priority, options.ResponseAddresses, payload, response)
+		cancel()
+
+		if err == nil {
+			if options.valid(payload, resp) {
+				return resp, nil
+			}
+			err = ErrorBadResponse
+		} else if !errors.Is(err, ErrorTimeout) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"sync"
+)
+
+// CommandPriority decides the order in which waiting commands get access to the bus.
+type CommandPriority int
+
+const (
+	// PriorityBackground is used for periodic polling and enumeration.
+	PriorityBackground CommandPriority = 0
+	// PriorityNormal is used by CommandExec and CommandExecTimeout.
+	PriorityNormal CommandPriority = 1
+	// PriorityInteractive is meant for commands a user is waiting for, like writing a configuration.
+	PriorityInteractive CommandPriority = 2
+
+	numPriorities = 3
+)
+
+/* Hands out command slots to waiters in priority order, first come first served within a priority */
+type cmdQueue struct {
+	sync.Mutex
+
+	allMutex sync.Mutex
+
+	slots   int
+	free    int
+	waiters [numPriorities][]chan (struct{})
+}
+
+func newCmdQueue(slots int) *cmdQueue {
+	return &cmdQueue{
+		slots: slots,
+		free:  slots,
+	}
+}
+
+func (q *cmdQueue) acquire(ctx context.Context, priority CommandPriority) error {
+	if priority < 0 {
+		priority = 0
+	} else if priority >= numPriorities {
+		priority = numPriorities - 1
+	}
+
+	q.Lock()
+	if q.free > 0 {
+		q.free--
+		q.Unlock()
+		return nil
+	}
+
+	ch := make(chan (struct{}))
+	q.waiters[priority] = append(q.waiters[priority], ch)
+	q.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.Lock()
+	defer q.Unlock()
+
+	list := q.waiters[priority]
+	for i, w := range list {
+		if w == ch {
+			q.waiters[priority] = append(list[:i], list[i+1:]...)
+			return ctx.Err()
+		}
+	}
+
+	/* The slot was granted while the context expired, pass it on */
+	q.releaseLocked()
+	return ctx.Err()
+}
+
+func (q *cmdQueue) release() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.releaseLocked()
+}
+
+func (q *cmdQueue) releaseLocked() {
+	for p := numPriorities - 1; p >= 0; p-- {
+		if list := q.waiters[p]; len(list) > 0 {
+			close(list[0])
+			q.waiters[p] = list[1:]
+			return
+		}
+	}
+	q.free++
+}
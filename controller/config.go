@@ -0,0 +1,117 @@
+package controller
+
+import "time"
+
+// Config contains the timing parameters of the controller. Fields that are left zero use the
+// default value.
+type Config struct {
+	// ScanWindow is the time after a presence signal during which the controller keeps scanning
+	// for new devices every cycle. Default: 20 seconds.
+	ScanWindow time.Duration
+
+	// ScanSkip is the number of poll cycles between scans outside of the scan window. Default: 10.
+	ScanSkip int
+
+	// BreakDuration is the length of the break that is sent to wake up devices when the bus is
+	// empty. Default: 200 milliseconds.
+	BreakDuration time.Duration
+
+	// BreakSettle is the time to wait after the break before sending the first command.
+	// Default: 30 milliseconds.
+	BreakSettle time.Duration
+
+	// CommandTimeout is the timeout used for enumeration commands and for CommandExecTimeout()
+	// calls with a zero timeout. Default: 150 milliseconds.
+	CommandTimeout time.Duration
+
+	// MonitorTimeout is the time after which a device that was not seen by a controller in
+	// monitor mode is considered gone. Default: 10 seconds.
+	MonitorTimeout time.Duration
+
+	// ForeignMasterHoldoff is the time the controller stays off the bus after it saw traffic
+	// from another master. Default: 5 seconds.
+	ForeignMasterHoldoff time.Duration
+
+	// TimeoutErrors makes BusDevice.CommandExecTimeout() return ErrorTimeout when the device does
+	// not answer, instead of returning nil for both the response and the error.
+	TimeoutErrors bool
+
+	// DisconnectFailures is the number of consecutive failed Access() calls after which a device
+	// is considered gone. Default: 1.
+	DisconnectFailures int
+
+	// KeepalivePing makes the controller check if a device is still present before disconnecting
+	// it, by sending its address assignment again.
+	KeepalivePing bool
+
+	// MaxUtilization is the largest fraction of time, between 0 and 1, that the bus may be busy
+	// with commands. The controller waits between commands to respect it. Zero means no limit.
+	MaxUtilization float64
+
+	// SlotTime enables the time slot scheduler. Each poll cycle gets one slot of this length per
+	// device, or priority+1 slots for devices with a priority, in address order. A device is accessed
+	// at most once per slot and the controller waits for the end of each slot, so the polling
+	// pattern is reproducible and the time between two polls of a device is bounded. When zero,
+	// devices are polled as fast as possible.
+	SlotTime time.Duration
+
+	// DuplicateSerials makes the controller give devices that use the serial of a device that is
+	// already known their own address and BusDevice, instead of ignoring them. This relies on
+	// devices that already have an address ignoring the address assignment. DeviceBySerial()
+	// returns the first device with a serial.
+	DuplicateSerials bool
+
+	// ScanBackoffMax enables exponential backoff of scans while no devices are known. The delay
+	// between scans starts at 100 milliseconds and doubles up to this value. It is reset when a
+	// presence signal is received or TriggerScan() is called. When zero, the bus is scanned
+	// continuously.
+	ScanBackoffMax time.Duration
+
+	// AccessDeadline is the time a single call to Access() is expected to take at most. Slower
+	// calls are logged and reported to Controller.OnAccessDeadline. It can be overridden per
+	// device using BusDevice.SetAccessDeadline(). When zero, the duration is not checked.
+	AccessDeadline time.Duration
+
+	// ClockJumpThreshold enables detection of host suspend: when the wall clock and the monotonic
+	// clock drift apart by more than this value, for example because the monotonic clock stopped
+	// while the host was asleep, the controller behaves as if Wake() was called. When zero, the
+	// clocks are not compared.
+	ClockJumpThreshold time.Duration
+
+	// JournalSize is the number of recent commands that are kept per device, with their response,
+	// duration and error, see BusDevice.Journal(). When zero, no journal is kept.
+	JournalSize int
+
+	// Clock is the source of time of the controller, see Clock. Default: the system clock.
+	Clock Clock
+}
+
+func (c *Config) setDefaults() {
+	if c.ScanWindow == 0 {
+		c.ScanWindow = 20 * time.Second
+	}
+	if c.ScanSkip == 0 {
+		c.ScanSkip = 10
+	}
+	if c.BreakDuration == 0 {
+		c.BreakDuration = 200 * time.Millisecond
+	}
+	if c.BreakSettle == 0 {
+		c.BreakSettle = 30 * time.Millisecond
+	}
+	if c.CommandTimeout == 0 {
+		c.CommandTimeout = 150 * time.Millisecond
+	}
+	if c.MonitorTimeout == 0 {
+		c.MonitorTimeout = 10 * time.Second
+	}
+	if c.ForeignMasterHoldoff == 0 {
+		c.ForeignMasterHoldoff = 5 * time.Second
+	}
+	if c.DisconnectFailures == 0 {
+		c.DisconnectFailures = 1
+	}
+	if c.Clock == nil {
+		c.Clock = systemClock{}
+	}
+}
@@ -2,6 +2,10 @@ package controller
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,57 +15,208 @@ import (
 )
 
 // Controller is a module that runs as the host of the BattGO compatible network.
+//
+// The command methods of BusDevice may be called from any goroutine while Run() is polling. All
+// commands, including those of the poll loop and the enumeration, pass through one queue, so a
+// command and its response are never interleaved with other traffic to the same address, and a
+// break is only sent when no command is outstanding. Use BusDevice.Transaction() when a sequence
+// of commands must not be interleaved with commands to other devices.
 type Controller struct {
+	// OnDeviceAdded is an optional callback that is called when a new device has been enumerated
+	// and its FunctionalDevice has been created.
+	OnDeviceAdded func(device *BusDevice)
+
+	// OnDeviceRemoved is an optional callback that is called after a device left the bus and
+	// its FunctionalDevice was disconnected. With an Eviction policy, it is called when the
+	// device is evicted.
+	OnDeviceRemoved func(device *BusDevice)
+
+	// OnDeviceCount is an optional callback that is called from Run() when the number of known
+	// devices changed, with the new number.
+	OnDeviceCount func(count int)
+
+	// OnDeviceError is an optional callback that decides what happens when Access() or Disconnected()
+	// of a FunctionalDevice returns an error. When it is nil, Run() terminates with the error.
+	OnDeviceError func(device *BusDevice, err error) ErrorAction
+
+	// OnBusBusy is an optional callback that is called when another master starts or stops using
+	// the bus. Frames sent from the controller address that were not transmitted by this controller
+	// are considered to come from another master. Breaks sent by another master cannot be told apart
+	// from device presence signals and are not detected.
+	OnBusBusy func(busy bool)
+
+	// ClassFactories maps device classes, as returned by BusDevice.GetClass(), to the function that
+	// creates their FunctionalDevice. When it is not empty, the controller reads the identification
+	// page of each new device before creating its FunctionalDevice. Devices with a class that is not
+	// in the map use the function given to New(). It must be set before calling Run(), use
+	// RegisterClassFactory() to add factories later on.
+	ClassFactories map[string]func(device *BusDevice) FunctionalDevice
+
+	// OnAccessDeadline is an optional callback that is called from Run() when Access() of a device
+	// took longer than its deadline, see Config.AccessDeadline and BusDevice.SetAccessDeadline().
+	OnAccessDeadline func(device *BusDevice, duration time.Duration)
+
+	// OnScanEvent is an optional callback that reports the progress of the enumeration. Presence
+	// events are reported from the receive goroutine of the PHY, the others from Run().
+	OnScanEvent func(event ScanEvent)
+
+	// OnDuplicateSerial is an optional callback that is called once per serial when a second device
+	// with the same serial is found. See also DuplicateSerials in Config.
+	OnDuplicateSerial func(serial []byte)
+
+	// SerialAllowList limits the devices that get a FunctionalDevice to the given serials. When it
+	// is empty all devices are allowed. Devices that are not allowed are still enumerated and
+	// visible using Devices(), but BusDevice.Skipped() returns true for them.
+	SerialAllowList [][]byte
+
+	// SerialDenyList contains serials of devices that should not get a FunctionalDevice. It is
+	// applied after SerialAllowList.
+	SerialDenyList [][]byte
+
+	// StaticAddresses maps serials, as string(serial), to the bus address they should always get.
+	// These addresses are never assigned to other devices. Devices that are not in the map get a
+	// free address. It must be set before calling Run(), which returns ErrorStaticAddress if an
+	// address is reserved or used for more than one serial.
+	StaticAddresses map[string]uint8
+
+	// PollInterval is the default minimum time between two calls to Access() of the same device.
+	// It can be overridden per device using BusDevice.SetPollInterval(). When zero, devices are
+	// polled as fast as the bus allows.
+	PollInterval time.Duration
+
+	// Logger is an optional logger for enumeration events, address assignments, command failures
+	// and disconnects.
+	Logger *slog.Logger
+
+	// Metrics is an optional interface that is informed of poll loop timing, scans, enumerations
+	// and commands.
+	Metrics Metrics
+
+	// CommandSlots is the number of commands that may be outstanding at the same time. Responses
+	// are matched on their source address, so commands to the same address are always serialized.
+	// When it is larger than 1, Run() calls Access() of up to CommandSlots devices at the same
+	// time, so their commands overlap on the bus. Calls for the same device never overlap. This
+	// requires devices that do not answer while another frame is being transmitted. With SlotTime,
+	// devices are still polled one at a time. It must be set before calling Run(). The default is 1.
+	CommandSlots int
+
+	// Eviction is an optional policy that keeps devices that left the bus for some time, so they
+	// can be attached again quickly. When nil, departed devices are forgotten immediately.
+	Eviction EvictionPolicy
+
+	// OnPHYFailure is an optional callback that is called when Run() of the PHY returned, for
+	// example because a USB adapter was unplugged. It can return a new PHY, which the controller
+	// will start and use from then on. Known devices are kept. If the callback is nil or returns
+	// nil, Run() terminates with the error of the PHY.
+	OnPHYFailure func(err error) *phy.PHY
+
+	phyMutex sync.Mutex
+	phyDone  chan (error)
+	closing  uint32
+	paused   uint32
+
+	suspended   uint32
+	wakeRequest uint32
+	clockLast   time.Time
+
+	reenumerateRequest  uint32
+	resetRequest        uint32
+	reenumerateDeadline time.Time
+
 	phy    *phy.PHY
 	newDev func(device *BusDevice) FunctionalDevice
+	config Config
 
 	scanTimeMutex sync.Mutex
 	scanTime      time.Time
 	scanCount     int
+	scanForce     uint32
+	scanBackoff   time.Duration
+	scanWake      chan (struct{})
+	scanBreakTime int64
+
+	cmdSlotSet  *slotset.SlotSet
+	cmdQueue    *cmdQueue
+	cmdAddrLock [256]chan (struct{})
 
-	cmdSlotSet *slotset.SlotSet
+	duplicatesReported map[string]bool
 
+	devicesMutex  sync.Mutex
 	devices       map[string]*BusDevice
+	stale         map[string]staleDevice
 	devicesNumber int
 	devicesMax    uint32
+	devicesCount  int
+
+	addressMutex sync.Mutex
+	addressUsed  [4]uint64
+
+	errors errorLog
 
-	addressUsed [4]uint64
+	hooks       commandHooks
+	factories   deviceFactories
+	foreign     foreignMaster
+	utilization utilization
+
+	schedulePass float64
+
+	monitor     bool
+	monitorChan chan (monitorPacket)
 }
 
 type cmdData struct {
-	addrResponse uint8
+	addrResponse []uint8
 	response     []byte
 }
 
+func newCmdSlotSet(slots int) *slotset.SlotSet {
+	return slotset.New(slots, func(slot *slotset.Slot) {
+		slot.Data = &cmdData{}
+	})
+}
+
 // New creates a controller. You need to specify a PHY, the amount of devices on the bus and a callback
 // that will be called when a new device is detected.
 // If the number of devices is not known two special values can be given:
 //   0: Scan continuously for new devices
 //  -1: Scan periodically and whenever the number of visible devices is less than the maximum.
 func New(phy *phy.PHY, numDevices int, newDev func(device *BusDevice) FunctionalDevice) *Controller {
+	return NewWithConfig(phy, numDevices, newDev, nil)
+}
+
+// NewWithConfig creates a controller like New, but allows tuning the timing parameters. When
+// config is nil the defaults are used.
+func NewWithConfig(phy *phy.PHY, numDevices int, newDev func(device *BusDevice) FunctionalDevice, config *Config) *Controller {
 	c := &Controller{
 		phy:    phy,
 		newDev: newDev,
 
-		cmdSlotSet: slotset.New(1, func(slot *slotset.Slot) {
-			slot.Data = &cmdData{}
-		}),
+		CommandSlots: 1,
+		cmdSlotSet:   newCmdSlotSet(1),
+		cmdQueue:     newCmdQueue(1),
 
 		devicesNumber: numDevices,
 		devices:       make(map[string]*BusDevice),
+		stale:         make(map[string]staleDevice),
+
+		duplicatesReported: make(map[string]bool),
+		scanWake:           make(chan (struct{}), 1),
+	}
+
+	if config != nil {
+		c.config = *config
+	}
+	c.config.setDefaults()
+
+	for i := range c.cmdAddrLock {
+		c.cmdAddrLock[i] = make(chan (struct{}), 1)
 	}
 
 	c.addressSetUsed(0x00, true) //Broadcast
 	c.addressSetUsed(0x01, true) //Controller
 	c.addressSetUsed(0xaa, true) //Escape
 
-	c.phy.RXHandlePacket = c.rxHandlePacket
-	if numDevices < 0 {
-		c.phy.RXHandlePresense = func(b byte) error {
-			c.detectStart()
-			return nil
-		}
-	}
+	c.phyAttach(phy)
 
 	return c
 }
@@ -71,14 +226,38 @@ func (c *Controller) GetMaxDevices() int {
 	return int(atomic.LoadUint32(&c.devicesMax))
 }
 
+// Devices returns all devices that are currently known on the bus.
+func (c *Controller) Devices() []*BusDevice {
+	c.devicesMutex.Lock()
+	defer c.devicesMutex.Unlock()
+
+	result := make([]*BusDevice, 0, len(c.devices))
+	for _, dev := range c.devices {
+		result = append(result, dev)
+	}
+	return result
+}
+
+// DeviceBySerial returns the device with the given serial, or nil if it is not known.
+func (c *Controller) DeviceBySerial(serial []byte) *BusDevice {
+	c.devicesMutex.Lock()
+	defer c.devicesMutex.Unlock()
+
+	return c.devices[string(serial)]
+}
+
 func (c *Controller) rxHandlePacket(addrSource uint8, addrDest uint8, payload []byte) error {
+	if addrSource == 1 {
+		c.foreignReceived(addrDest, payload)
+	}
+
 	if addrDest != 1 {
 		return nil
 	}
 
 	return c.cmdSlotSet.IterateActive(func(slot *slotset.Slot) (bool, error) {
 		data := slot.Data.(*cmdData)
-		if data.addrResponse == addrSource {
+		if data.accepts(addrSource) {
 			data.response = append(data.response[:0], payload...)
 			slot.PostWithoutLock(nil)
 		}
@@ -86,7 +265,80 @@ func (c *Controller) rxHandlePacket(addrSource uint8, addrDest uint8, payload []
 	})
 }
 
-func (c *Controller) commandExec(ctx context.Context, addrDest uint8, addrResponse uint8, payload []byte, response []byte) ([]byte, error) {
+func (c *Controller) commandExec(ctx context.Context, priority CommandPriority, addrDest uint8, addrResponse []uint8, payload []byte, response []byte) ([]byte, error) {
+	if c.monitor {
+		return nil, ErrorMonitorMode
+	}
+
+	var trace *CommandTrace
+	if !c.hooks.empty() {
+		trace = &CommandTrace{
+			AddrDest:     addrDest,
+			AddrResponse: addrResponse[0],
+			Priority:     priority,
+			Payload:      payload,
+		}
+		c.hooks.run(&c.hooks.before, trace)
+	}
+
+	start := time.Now()
+	var resp []byte
+	var err error
+	if c.BusBusy() {
+		err = ErrorBusBusy
+	} else {
+		resp, err = c.commandExecSlot(ctx, priority, addrDest, addrResponse, payload, response)
+		if errors.Is(err, context.DeadlineExceeded) {
+			/* Wrapped, so callers can still test for the error of their context */
+			err = fmt.Errorf("%w: %w", ErrorTimeout, err)
+		}
+	}
+	latency := time.Since(start)
+
+	if c.Metrics != nil {
+		c.Metrics.OnCommand(addrDest, latency, err)
+	}
+
+	if trace != nil {
+		trace.Response = resp
+		trace.Latency = latency
+		trace.Err = err
+		c.hooks.run(&c.hooks.after, trace)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Controller) commandExecSlot(ctx context.Context, priority CommandPriority, addrDest uint8, addrResponse []uint8, payload []byte, response []byte) ([]byte, error) {
+	if !cmdHeld(ctx) {
+		if err := c.cmdQueue.acquire(ctx, priority); err != nil {
+			return nil, err
+		}
+		defer c.cmdQueue.release()
+	}
+
+	/* The address locks are always taken after the queue, so a transaction holding the whole
+	 * queue cannot deadlock. */
+	unlock, err := c.addrLock(ctx, addrResponse)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if c.Paused() {
+		return nil, ErrorPaused
+	}
+	if c.isSuspended() {
+		return nil, ErrorSuspended
+	}
+
+	if err := c.utilization.wait(ctx, c.config.Clock); err != nil {
+		return nil, err
+	}
+
 	slot, err := c.cmdSlotSet.Get(ctx)
 	if err != nil {
 		return nil, err
@@ -99,7 +351,9 @@ func (c *Controller) commandExec(ctx context.Context, addrDest uint8, addrRespon
 	data.response = response
 
 	slot.Activate()
-	err = c.phy.TXSendPacket(1, addrDest, payload)
+	defer c.utilization.busy(c.config.Clock, c.config.Clock.Now(), c.config.MaxUtilization)
+	c.foreign.transmitted(c.config.Clock.Now(), addrDest, payload)
+	err = c.getPHY().TXSendPacket(1, addrDest, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -114,26 +368,34 @@ func (c *Controller) commandExec(ctx context.Context, addrDest uint8, addrRespon
 	return data.response, nil
 }
 
-func (c *Controller) commandExecTimeout(timeout time.Duration, addrDest uint8, addrResponse uint8, payload []byte, response []byte) ([]byte, error) {
+func (c *Controller) commandExecTimeout(ctx context.Context, timeout time.Duration, priority CommandPriority, addrDest uint8, addrResponse []uint8, payload []byte, response []byte) ([]byte, error) {
 	if timeout == 0 {
-		timeout = 150 * time.Millisecond
+		timeout = c.config.CommandTimeout
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	resp, err := c.commandExec(ctx, addrDest, addrResponse, payload, response)
-	if ctx.Err() != nil {
-		return nil, nil
+	resp, err := c.commandExec(ctx, priority, addrDest, addrResponse, payload, response)
+	if errors.Is(err, ErrorTimeout) {
+		c.log().Debug("Command timed out", "address", addrDest, "payload", payload)
+	} else if errors.Is(err, context.Canceled) {
+		/* Cancelled by the caller, for example because the device was closed */
+	} else if err != nil {
+		c.log().Warn("Command failed", "address", addrDest, "error", err)
+		c.errors.add(addrDest, err)
 	}
 
 	return resp, err
 }
 
 func (c *Controller) addressFindFree() (byte, bool) {
+	c.addressMutex.Lock()
+	defer c.addressMutex.Unlock()
+
 	for i := 0; i < 254; i++ {
 		mask := (uint64(1) << (i % 64))
 		if (c.addressUsed[i/64] & mask) == 0 {
-			c.addressSetUsed(byte(i), true)
+			c.addressSetUsedLocked(byte(i), true)
 			return byte(i), true
 		}
 	}
@@ -141,6 +403,13 @@ func (c *Controller) addressFindFree() (byte, bool) {
 }
 
 func (c *Controller) addressSetUsed(addr byte, used bool) {
+	c.addressMutex.Lock()
+	defer c.addressMutex.Unlock()
+
+	c.addressSetUsedLocked(addr, used)
+}
+
+func (c *Controller) addressSetUsedLocked(addr byte, used bool) {
 	mask := (uint64(1) << (addr % 64))
 	if used {
 		c.addressUsed[addr/64] |= mask
@@ -151,40 +420,195 @@ func (c *Controller) addressSetUsed(addr byte, used bool) {
 
 // Run starts the controller and will return on an error or when Close() is called.
 func (c *Controller) Run() error {
-	go c.phy.Run()
+	if c.CommandSlots > 1 {
+		c.cmdSlotSet = newCmdSlotSet(c.CommandSlots)
+		c.cmdQueue = newCmdQueue(c.CommandSlots)
+	}
+
+	if c.monitor {
+		c.phyStart()
+		return c.runMonitor()
+	}
+
+	if err := c.addressReserveStatic(); err != nil {
+		return err
+	}
+
+	c.phyStart()
 
 	c.detectStart()
 
 	for {
+		if err := c.phyCheck(nil); err != nil {
+			return err
+		}
+
+		c.wakeStart()
+		if c.Paused() || c.isSuspended() || c.foreignBackoff() {
+			c.config.Clock.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if err := c.resetStart(); err != nil {
+			return err
+		}
+
 		err := c.detectAndConfigure()
 		if err != nil {
-			return err
+			if err := c.phyCheck(err); err != nil {
+				return err
+			}
+			continue
 		}
 
 		for _, dev := range c.devices {
 			if dev.isClosed() {
-				err := dev.device.Disconnected()
-				c.addressSetUsed(dev.address, false)
-				delete(c.devices, string(dev.serial))
-				if err != nil {
+				if err := c.deviceRemove(dev); err != nil {
 					return err
 				}
-				continue
 			}
+		}
+		c.staleEvict(false)
+		c.deviceCountCheck()
+
+		var pollNext time.Time
+		polled := false
+		pollStart := c.config.Clock.Now()
+		accessed := 0
+
+		devs := c.scheduleDevices()
+		if c.config.SlotTime > 0 {
+			accessed, err = c.scheduleSlots(devs)
+			polled = true
+		} else if c.CommandSlots > 1 {
+			accessed, pollNext, err = c.schedulePipelined(devs)
+			polled = accessed > 0
+		} else {
+			/* Poll as many times as there are devices, so scanning keeps its share of the bus */
+			for range devs {
+				var dev *BusDevice
+				dev, pollNext = c.schedulePick(devs)
+				if dev == nil {
+					break
+				}
+				polled = true
+				accessed++
 
-			active, err := dev.device.Access()
-			if !active {
-				dev.close()
+				if err = c.deviceAccess(dev); err != nil {
+					break
+				}
 			}
+		}
 
-			if err != nil {
+		if err != nil {
+			if err := c.phyCheck(err); err != nil {
 				return err
 			}
 		}
+
+		if c.Metrics != nil {
+			c.Metrics.OnPollCycle(c.config.Clock.Now().Sub(pollStart), accessed)
+		}
+
+		/* Nothing was due, wait instead of spinning */
+		if !polled && !pollNext.IsZero() {
+			wait := pollNext.Sub(c.config.Clock.Now())
+			if wait > 100*time.Millisecond {
+				wait = 100 * time.Millisecond
+			}
+			c.config.Clock.Sleep(wait)
+		}
+	}
+}
+
+/* Calls Access() of a device and handles the result, returns an error if Run() should terminate */
+func (c *Controller) deviceAccess(dev *BusDevice) error {
+	start := c.config.Clock.Now()
+	active, err := c.deviceAccessCall(dev)
+	return c.deviceAccessResult(dev, active, err, c.config.Clock.Now().Sub(start))
+}
+
+/* Calls Access() or AccessNext() of a device. It may run concurrently for different devices. */
+func (c *Controller) deviceAccessCall(dev *BusDevice) (bool, error) {
+	pd, ok := dev.device.(PacedDevice)
+	if !ok {
+		return dev.device.Access()
+	}
+
+	active, next, err := pd.AccessNext()
+	dev.Lock()
+	dev.pollNext = next
+	dev.Unlock()
+	return active, err
+}
+
+/* Handles the result of a call to Access(), returns an error if Run() should terminate */
+func (c *Controller) deviceAccessResult(dev *BusDevice, active bool, err error, duration time.Duration) error {
+	c.accessDeadlineCheck(dev, duration)
+
+	if errors.Is(err, ErrorBusBusy) || errors.Is(err, ErrorPaused) || errors.Is(err, ErrorSuspended) {
+		/* Another master showed up or we were paused or suspended, this is not the fault of the device */
+		active, err = true, nil
+	}
+
+	if active {
+		c.deviceSucceeded(dev)
+	} else if !c.deviceFailed(dev) {
+		active = true
+	}
+
+	action := ErrorActionContinue
+	if err != nil {
+		c.log().Warn("Device access failed", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "error", err)
+		c.errors.add(dev.address, err)
+		action = c.deviceErrorAction(dev, err)
+		if action == ErrorActionDisconnect {
+			active = false
+		}
+	}
+
+	if !active {
+		dev.close()
+	}
+
+	if action == ErrorActionStop {
+		return err
+	}
+	return nil
+}
+
+/* Removes a device from the bus, returns an error if Run() should terminate */
+func (c *Controller) deviceRemove(dev *BusDevice) error {
+	c.log().Info("Device disconnected", "serial", hex.EncodeToString(dev.serial), "address", dev.address)
+
+	dev.close()
+	err := dev.device.Disconnected()
+	if !c.staleAdd(dev) {
+		c.addressRelease(dev.address)
+		c.devicesMutex.Lock()
+		delete(c.devices, dev.key)
+		c.devicesMutex.Unlock()
+		c.deviceForget(dev)
+	}
+	if err != nil && c.deviceErrorAction(dev, err) == ErrorActionStop {
+		return err
+	}
+	return nil
+}
+
+/* Called once a device is no longer remembered by the controller */
+func (c *Controller) deviceForget(dev *BusDevice) {
+	if removed, ok := dev.device.(RemovedDevice); ok {
+		removed.Removed()
+	}
+	if c.OnDeviceRemoved != nil && !dev.deviceNew {
+		c.OnDeviceRemoved(dev)
 	}
 }
 
 // Make Run() return and close the underlying PHY.
 func (c *Controller) Close() error {
-	return c.phy.Close()
+	atomic.StoreUint32(&c.closing, 1)
+	c.scanBackoffReset()
+	return c.getPHY().Close()
 }
@@ -0,0 +1,415 @@
+package controller_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/controller"
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+/* simulatedDevice answers address assignment and serial page reads on the other end of a
+ * virtual line */
+type simulatedDevice struct {
+	sync.Mutex
+
+	phy     *phy.PHY
+	serial  []byte
+	address uint8
+	reads   int
+}
+
+func newSimulatedDevice(t *testing.T, serial []byte) (*phy.PHY, *simulatedDevice) {
+	p, port, err := phy.NewVirtualPair()
+	if err != nil {
+		t.Skipf("Virtual line not available: %v", err)
+	}
+
+	s := &simulatedDevice{
+		phy:    &phy.PHY{Port: port},
+		serial: serial,
+	}
+	s.phy.RXHandlePacket = s.rxHandlePacket
+	go s.phy.Run()
+
+	return p, s
+}
+
+func (s *simulatedDevice) rxHandlePacket(addrSource uint8, addrDest uint8, payload []byte) error {
+	if addrSource != 1 || len(payload) == 0 {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if addrDest == 0 && len(payload) == 12 && payload[0] == 2 {
+		if payload[1] == 0 && s.address == 0 {
+			go s.phy.TXSendPacket(0, 1, append([]byte{3}, s.serial...))
+		} else if payload[1] != 0 && bytes.Equal(payload[2:], s.serial) {
+			s.address = payload[1]
+			go s.phy.TXSendPacket(s.address, 1, append([]byte{3}, s.serial...))
+		}
+		return nil
+	}
+
+	if addrDest == s.address && s.address != 0 && payload[0] == 0x84 {
+		s.reads++
+		go s.phy.TXSendPacket(s.address, 1, append(append([]byte{0x85}, s.serial...), 'T', 0))
+	}
+	return nil
+}
+
+func (s *simulatedDevice) state() (uint8, int) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.address, s.reads
+}
+
+type serialReader struct {
+	device *controller.BusDevice
+}
+
+func (r *serialReader) Access() (bool, error) {
+	response, err := r.device.CommandExecTimeout(0, []byte{0x84}, nil)
+	return response != nil, err
+}
+
+func (r *serialReader) Disconnected() error {
+	return nil
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestEnumerateAndPoll(t *testing.T) {
+	serial := []byte("0123456789")
+	p, sim := newSimulatedDevice(t, serial)
+
+	c := controller.New(p, 1, func(device *controller.BusDevice) controller.FunctionalDevice {
+		return &serialReader{device: device}
+	})
+	c.PollInterval = 20 * time.Millisecond
+	defer c.Close()
+
+	result := make(chan (error), 1)
+	go func() {
+		result <- c.Run()
+	}()
+
+	if !waitFor(t, 5*time.Second, func() bool { return c.DeviceBySerial(serial) != nil }) {
+		t.Fatal("Device was not enumerated")
+	}
+
+	dev := c.DeviceBySerial(serial)
+	address, _ := sim.state()
+	if dev.GetAddress() != address || address < 2 {
+		t.Fatalf("Device has address %d, simulated device has %d", dev.GetAddress(), address)
+	}
+
+	if !waitFor(t, 5*time.Second, func() bool { _, reads := sim.state(); return reads >= 3 }) {
+		t.Fatal("Device was not polled")
+	}
+
+	c.Close()
+	select {
+	case <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+}
+
+func TestCommandTimeoutWrapsDeadline(t *testing.T) {
+	serial := []byte("5555555555")
+	p, _ := newSimulatedDevice(t, serial)
+
+	c := controller.New(p, 1, func(device *controller.BusDevice) controller.FunctionalDevice {
+		return &serialReader{device: device}
+	})
+	defer c.Close()
+	go c.Run()
+
+	if !waitFor(t, 5*time.Second, func() bool { return c.DeviceBySerial(serial) != nil }) {
+		t.Fatal("Device was not enumerated")
+	}
+
+	/* The simulated device does not answer this opcode */
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.DeviceBySerial(serial).CommandExec(ctx, []byte{0x10}, nil)
+	if !errors.Is(err, controller.ErrorTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a timeout that wraps the context error, got %v", err)
+	}
+}
+
+func TestStaticAddressValidation(t *testing.T) {
+	for _, static := range []map[string]uint8{
+		{"0123456789": 5, "9876543210": 5},
+		{"0123456789": 0xff},
+	} {
+		p, _, err := phy.NewVirtualPair()
+		if err != nil {
+			t.Skipf("Virtual line not available: %v", err)
+		}
+
+		c := controller.New(p, 1, nil)
+		c.StaticAddresses = static
+		if err := c.Run(); !errors.Is(err, controller.ErrorStaticAddress) {
+			t.Errorf("Expected ErrorStaticAddress for %v, got %v", static, err)
+		}
+		c.Close()
+	}
+}
+
+func TestMonitorLearnsFromSerialPage(t *testing.T) {
+	serial := []byte("4444444444")
+	p, port, err := phy.NewVirtualPair()
+	if err != nil {
+		t.Skipf("Virtual line not available: %v", err)
+	}
+	peer := &phy.PHY{Port: port}
+	go peer.Run()
+
+	c := controller.NewMonitor(p, func(device *controller.BusDevice) controller.FunctionalDevice {
+		return &serialReader{device: device}
+	}, nil)
+	defer c.Close()
+	go c.Run()
+
+	/* The answer of an already enumerated device to a serial page read of another master */
+	if !waitFor(t, 5*time.Second, func() bool {
+		peer.TXSendPacket(7, 1, append(append([]byte{0x85}, serial...), 'T', 0))
+		return c.DeviceBySerial(serial) != nil
+	}) {
+		t.Fatal("Device was not learned from the serial page")
+	}
+	if address := c.DeviceBySerial(serial).GetAddress(); address != 7 {
+		t.Fatalf("Device has address %d, expected 7", address)
+	}
+}
+
+type removedReader struct {
+	serialReader
+
+	sync.Mutex
+	disconnected int
+	removed      int
+}
+
+func (r *removedReader) Disconnected() error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.disconnected++
+	return nil
+}
+
+func (r *removedReader) Removed() {
+	r.Lock()
+	defer r.Unlock()
+
+	/* Removed is always preceded by Disconnected */
+	if r.disconnected > 0 {
+		r.removed++
+	}
+}
+
+func (r *removedReader) state() (int, int) {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.disconnected, r.removed
+}
+
+func TestRemovedDevice(t *testing.T) {
+	for _, eviction := range []controller.EvictionPolicy{nil, controller.EvictAfter(time.Hour)} {
+		serial := []byte("3333333333")
+		p, _ := newSimulatedDevice(t, serial)
+
+		var readersMutex sync.Mutex
+		var readers []*removedReader
+		c := controller.New(p, 1, func(device *controller.BusDevice) controller.FunctionalDevice {
+			r := &removedReader{serialReader: serialReader{device: device}}
+
+			readersMutex.Lock()
+			readers = append(readers, r)
+			readersMutex.Unlock()
+			return r
+		})
+		c.PollInterval = 20 * time.Millisecond
+		c.Eviction = eviction
+		go c.Run()
+
+		first := func() *removedReader {
+			readersMutex.Lock()
+			defer readersMutex.Unlock()
+
+			if len(readers) == 0 {
+				return nil
+			}
+			return readers[0]
+		}
+		if !waitFor(t, 5*time.Second, func() bool { return first() != nil }) {
+			t.Fatal("Device was not enumerated")
+		}
+
+		/* A reset forgets the device, also when the eviction policy would keep it */
+		c.ResetBus()
+		if !waitFor(t, 5*time.Second, func() bool { _, removed := first().state(); return removed > 0 }) {
+			t.Fatalf("Removed was not called with eviction policy %v", eviction)
+		}
+		if disconnected, removed := first().state(); disconnected != 1 || removed != 1 {
+			t.Fatalf("Disconnected was called %d times and Removed %d times, expected once", disconnected, removed)
+		}
+
+		c.Close()
+	}
+}
+
+/* simulatedBus is a line with several devices that answer serial page reads after a delay */
+type simulatedBus struct {
+	sync.Mutex
+
+	phy       *phy.PHY
+	serials   [][]byte
+	addresses []uint8
+	delay     time.Duration
+}
+
+func newSimulatedBus(t *testing.T, delay time.Duration, serials ...[]byte) (*phy.PHY, *simulatedBus) {
+	p, port, err := phy.NewVirtualPair()
+	if err != nil {
+		t.Skipf("Virtual line not available: %v", err)
+	}
+
+	s := &simulatedBus{
+		phy:       &phy.PHY{Port: port},
+		serials:   serials,
+		addresses: make([]uint8, len(serials)),
+		delay:     delay,
+	}
+	s.phy.RXHandlePacket = s.rxHandlePacket
+	go s.phy.Run()
+
+	return p, s
+}
+
+func (s *simulatedBus) rxHandlePacket(addrSource uint8, addrDest uint8, payload []byte) error {
+	if addrSource != 1 || len(payload) == 0 {
+		return nil
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if addrDest == 0 && len(payload) == 12 && payload[0] == 2 {
+		for i, serial := range s.serials {
+			if payload[1] == 0 && s.addresses[i] == 0 {
+				/* Only one device answers a discovery, as if it won the collision */
+				go s.phy.TXSendPacket(0, 1, append([]byte{3}, serial...))
+				return nil
+			} else if payload[1] != 0 && bytes.Equal(payload[2:], serial) {
+				s.addresses[i] = payload[1]
+				go s.phy.TXSendPacket(s.addresses[i], 1, append([]byte{3}, serial...))
+				return nil
+			}
+		}
+		return nil
+	}
+
+	for i, serial := range s.serials {
+		if addrDest == s.addresses[i] && addrDest != 0 && payload[0] == 0x84 {
+			answer := append(append([]byte{0x85}, serial...), 'T', 0)
+			time.AfterFunc(s.delay, func() {
+				s.phy.TXSendPacket(addrDest, 1, answer)
+			})
+		}
+	}
+	return nil
+}
+
+/* concurrentReader reads the serial page and records how many reads were in flight at once */
+type concurrentReader struct {
+	serialReader
+	stats *concurrency
+}
+
+type concurrency struct {
+	sync.Mutex
+	current int
+	max     int
+	reads   int
+}
+
+func (r *concurrentReader) Access() (bool, error) {
+	r.stats.Lock()
+	r.stats.current++
+	if r.stats.current > r.stats.max {
+		r.stats.max = r.stats.current
+	}
+	r.stats.Unlock()
+
+	response, err := r.device.CommandExecTimeout(time.Second, []byte{0x84}, nil)
+
+	r.stats.Lock()
+	r.stats.current--
+	if response != nil {
+		r.stats.reads++
+	}
+	r.stats.Unlock()
+
+	return response != nil, err
+}
+
+func (c *concurrency) state() (int, int) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.max, c.reads
+}
+
+func TestPipelinedPolling(t *testing.T) {
+	for _, slots := range []int{1, 2} {
+		serials := [][]byte{[]byte("1111111111"), []byte("2222222222")}
+		p, _ := newSimulatedBus(t, 100*time.Millisecond, serials...)
+
+		stats := &concurrency{}
+		c := controller.New(p, len(serials), func(device *controller.BusDevice) controller.FunctionalDevice {
+			return &concurrentReader{serialReader: serialReader{device: device}, stats: stats}
+		})
+		c.PollInterval = 10 * time.Millisecond
+		c.CommandSlots = slots
+		go c.Run()
+
+		if !waitFor(t, 10*time.Second, func() bool {
+			return c.DeviceBySerial(serials[0]) != nil && c.DeviceBySerial(serials[1]) != nil
+		}) {
+			t.Fatal("Devices were not enumerated")
+		}
+
+		/* Both slow devices are read at the same time only when there are two slots */
+		if !waitFor(t, 5*time.Second, func() bool { _, reads := stats.state(); return reads >= 10 }) {
+			t.Fatal("Devices were not polled")
+		}
+		if max, _ := stats.state(); max != slots {
+			t.Fatalf("With %d slots, %d reads were in flight at the same time", slots, max)
+		}
+
+		c.Close()
+	}
+}
@@ -0,0 +1,27 @@
+package controller
+
+import "sync/atomic"
+
+// ResetMaxDevices sets the highest amount of devices ever seen back to the number of devices
+// that are currently known. Call it after intentionally removing devices, so a controller
+// created with -1 devices does not keep scanning for devices that are not coming back.
+func (c *Controller) ResetMaxDevices() {
+	c.devicesMutex.Lock()
+	defer c.devicesMutex.Unlock()
+
+	atomic.StoreUint32(&c.devicesMax, uint32(len(c.devices)))
+}
+
+/* Called by Run(), reports a change of the number of known devices */
+func (c *Controller) deviceCountCheck() {
+	count := len(c.devices)
+	if count == c.devicesCount {
+		return
+	}
+	c.devicesCount = count
+
+	c.log().Debug("Device count changed", "count", count)
+	if c.OnDeviceCount != nil {
+		c.OnDeviceCount(count)
+	}
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// SetAccessDeadline sets the time a single call to Access() of this device is expected to take.
+// When it takes longer, the OnAccessDeadline callback of the controller is called. When zero,
+// the AccessDeadline of the controller configuration is used.
+func (d *BusDevice) SetAccessDeadline(deadline time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.accessDeadline = deadline
+}
+
+/* Called after Access() returned, reports calls that took longer than the deadline */
+func (c *Controller) accessDeadlineCheck(dev *BusDevice, duration time.Duration) {
+	dev.Lock()
+	deadline := dev.accessDeadline
+	dev.Unlock()
+
+	if deadline == 0 {
+		deadline = c.config.AccessDeadline
+	}
+	if deadline <= 0 || duration <= deadline {
+		return
+	}
+
+	c.log().Warn("Device access exceeded deadline", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "duration", duration, "deadline", deadline)
+	if c.OnAccessDeadline != nil {
+		c.OnAccessDeadline(dev, duration)
+	}
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorRecord describes an error that occurred in the controller.
+type ErrorRecord struct {
+	Time    time.Time
+	Address uint8
+	Err     error
+}
+
+// DeviceState describes a device in the output of DebugState.
+type DeviceState struct {
+	Serial       []byte
+	Address      uint8
+	Closed       bool
+	Skipped      bool
+	Priority     int
+	PollInterval time.Duration
+	PollLast     time.Time
+	Stats        DeviceStats
+}
+
+// ControllerState is a snapshot of the internal state of the controller, intended for debugging.
+type ControllerState struct {
+	Devices    []DeviceState
+	Addresses  []uint8
+	MaxDevices int
+
+	ScanWindowEnd time.Time
+	ScanForced    bool
+
+	CommandSlots        int
+	CommandsOutstanding int
+	CommandsWaiting     int
+
+	BusBusy bool
+
+	// LastErrors contains the most recent errors, oldest first.
+	LastErrors []ErrorRecord
+}
+
+/* Number of errors kept for DebugState */
+const errorLogSize = 16
+
+type errorLog struct {
+	sync.Mutex
+
+	records [errorLogSize]ErrorRecord
+	next    int
+	count   int
+}
+
+func (l *errorLog) add(address uint8, err error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.records[l.next] = ErrorRecord{
+		Time:    time.Now(),
+		Address: address,
+		Err:     err,
+	}
+	l.next = (l.next + 1) % errorLogSize
+	if l.count < errorLogSize {
+		l.count++
+	}
+}
+
+func (l *errorLog) get() []ErrorRecord {
+	l.Lock()
+	defer l.Unlock()
+
+	result := make([]ErrorRecord, 0, l.count)
+	for i := 0; i < l.count; i++ {
+		result = append(result, l.records[(l.next-l.count+i+errorLogSize)%errorLogSize])
+	}
+	return result
+}
+
+func (q *cmdQueue) state() (int, int, int) {
+	q.Lock()
+	defer q.Unlock()
+
+	waiting := 0
+	for _, list := range q.waiters {
+		waiting += len(list)
+	}
+	return q.slots, q.slots - q.free, waiting
+}
+
+// DebugState returns a snapshot of the internal state of the controller. It is safe to call
+// from any goroutine.
+func (c *Controller) DebugState() ControllerState {
+	var state ControllerState
+
+	for _, dev := range c.Devices() {
+		dev.Lock()
+		state.Devices = append(state.Devices, DeviceState{
+			Serial:       dev.serial,
+			Address:      dev.address,
+			Closed:       dev.closed,
+			Skipped:      dev.skipped,
+			Priority:     dev.priority,
+			PollInterval: dev.pollInterval,
+			PollLast:     dev.pollLast,
+		})
+		dev.Unlock()
+		state.Devices[len(state.Devices)-1].Stats = dev.Stats()
+	}
+
+	c.addressMutex.Lock()
+	for i := 0; i < 256; i++ {
+		if c.addressUsed[i/64]&(uint64(1)<<(i%64)) != 0 {
+			state.Addresses = append(state.Addresses, uint8(i))
+		}
+	}
+	c.addressMutex.Unlock()
+
+	state.MaxDevices = c.GetMaxDevices()
+
+	c.scanTimeMutex.Lock()
+	state.ScanWindowEnd = c.scanTime
+	c.scanTimeMutex.Unlock()
+	state.ScanForced = atomic.LoadUint32(&c.scanForce) != 0
+
+	state.CommandSlots, state.CommandsOutstanding, state.CommandsWaiting = c.cmdQueue.state()
+	state.BusBusy = c.BusBusy()
+	state.LastErrors = c.errors.get()
+
+	return state
+}
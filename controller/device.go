@@ -10,6 +10,17 @@ import (
 var (
 	// ErrorClosed is returned when the device has already been closed.
 	ErrorClosed = errors.New("Device has been closed")
+	// ErrorTimeout is returned when a device did not answer a command before the deadline. The
+	// returned error wraps both ErrorTimeout and context.DeadlineExceeded, use errors.Is().
+	ErrorTimeout = errors.New("Command timed out")
+	// ErrorNoFreeAddress is reported when a new device was found but all addresses are in use. It
+	// is passed to OnScanEvent in a ScanEventFailed event and kept in DebugState().LastErrors.
+	ErrorNoFreeAddress = errors.New("No free bus address")
+	// ErrorBadResponse is reported when a device sent a response that does not match the command.
+	// During enumeration it is passed to OnScanEvent in a ScanEventFailed event.
+	ErrorBadResponse = errors.New("Unexpected response")
+	// ErrorBusBusy is returned when a command is sent while another master is using the bus.
+	ErrorBusBusy = errors.New("Bus is in use by another master")
 )
 
 // BusDevice represents a device on the BattGO compatible bus.
@@ -17,19 +28,97 @@ type BusDevice struct {
 	sync.Mutex
 
 	controller *Controller
+	key        string
 	serial     []byte
 	address    uint8
 	closed     bool
 
 	device    FunctionalDevice
 	deviceNew bool
+	skipped   bool
+
+	class          string
+	identification []byte
+
+	pollInterval   time.Duration
+	pollNext       time.Duration
+	pollLast       time.Time
+	pollPass       float64
+	pollPassSet    bool
+	priority       int
+	failures       int
+	unconfirmed    bool
+	accessDeadline time.Duration
+
+	stats   deviceStatsCounter
+	journal deviceJournal
+
+	closedChan chan (struct{})
 }
 
 func (d *BusDevice) close() {
 	d.Lock()
 	defer d.Unlock()
 
+	d.closeLocked()
+}
+
+func (d *BusDevice) closeLocked() {
+	if d.closed {
+		return
+	}
+
 	d.closed = true
+	if d.closedChan != nil {
+		close(d.closedChan)
+	}
+}
+
+/* Returns a channel that is closed when the device is closed */
+func (d *BusDevice) closedSignal() chan (struct{}) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.closedChan == nil {
+		d.closedChan = make(chan (struct{}))
+		if d.closed {
+			close(d.closedChan)
+		}
+	}
+	return d.closedChan
+}
+
+/* Returns a context that is cancelled with ErrorClosed when the device is closed, so commands
+ * that are waiting for the bus or for a response return immediately */
+func (d *BusDevice) closedContext(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	closed := d.closedSignal()
+
+	go func() {
+		select {
+		case <-closed:
+			cancel(ErrorClosed)
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+/* Replaces the error of a command that was cancelled because the device was closed */
+func closedError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrorClosed) {
+		return ErrorClosed
+	}
+	return err
+}
+
+// Detach makes the controller stop managing the device. The controller frees its address and calls
+// Disconnected() on the FunctionalDevice from Run(), other devices are not affected. If the device
+// answers an enumeration again later it is handled as a new device, add its serial to the
+// SerialDenyList of the controller to prevent this.
+func (d *BusDevice) Detach() {
+	d.close()
 }
 
 // GetSerial returns the serial of the device.
@@ -42,6 +131,31 @@ func (d *BusDevice) GetAddress() uint8 {
 	return d.address
 }
 
+// SetPollInterval sets the minimum time between two calls to Access() for this device. When
+// zero, the PollInterval of the controller is used.
+func (d *BusDevice) SetPollInterval(interval time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.pollInterval = interval
+}
+
+// Skipped returns true if the device was excluded by the serial allow or deny list of the controller.
+func (d *BusDevice) Skipped() bool {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.skipped
+}
+
+// GetDevice returns the FunctionalDevice that is attached to the device.
+func (d *BusDevice) GetDevice() FunctionalDevice {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.device
+}
+
 func (d *BusDevice) isClosed() bool {
 	d.Lock()
 	defer d.Unlock()
@@ -50,37 +164,100 @@ func (d *BusDevice) isClosed() bool {
 }
 
 // CommandExec sends a message to the device and returns the response. You can provide a slice
-// that will be used to store the response.
+// that will be used to store the response. If the device is closed while the command is waiting
+// for the bus or for the response, ErrorClosed is returned immediately.
 func (d *BusDevice) CommandExec(ctx context.Context, payload []byte, response []byte) ([]byte, error) {
+	return d.CommandExecPriority(ctx, PriorityNormal, payload, response)
+}
+
+// CommandExecPriority is like CommandExec, but waiting commands with a higher priority are sent first.
+func (d *BusDevice) CommandExecPriority(ctx context.Context, priority CommandPriority, payload []byte, response []byte) ([]byte, error) {
+	return d.commandExecFrom(ctx, priority, nil, payload, response)
+}
+
+/* Sends a command that accepts a response from the address of the device and from the
+ * additional addresses in accept */
+func (d *BusDevice) commandExecFrom(ctx context.Context, priority CommandPriority, accept []uint8, payload []byte, response []byte) ([]byte, error) {
 	if d.isClosed() {
 		return nil, ErrorClosed
 	}
 
-	return d.controller.commandExec(ctx, d.address, d.address, payload, response)
+	ctx, cancel := d.closedContext(ctx)
+	defer cancel(nil)
+
+	start := time.Now()
+	addrs := append([]uint8{d.address}, accept...)
+	resp, err := d.controller.commandExec(ctx, priority, d.address, addrs, payload, response)
+	err = closedError(ctx, err)
+	d.stats.record(start, resp != nil && err == nil)
+	d.journal.record(d.controller.config.JournalSize, start, payload, resp, err)
+	return resp, err
 }
 
 // CommandExecTimeout sends a message to the device and returns the response. You can provide a slice
-// that will be used to store the response. Convenience logic is provided to handle timeouts. When the
-// timeout value is 0, a reasonable default is used.
+// that will be used to store the response. When the timeout value is 0, a reasonable default is used.
+// On timeout, nil is returned for both the response and the error, unless TimeoutErrors is set in the
+// controller Config. In that case ErrorTimeout is returned.
 func (d *BusDevice) CommandExecTimeout(timeout time.Duration, payload []byte, response []byte) ([]byte, error) {
+	return d.CommandExecTimeoutPriority(timeout, PriorityNormal, payload, response)
+}
+
+// CommandExecTimeoutPriority is like CommandExecTimeout, but waiting commands with a higher priority
+// are sent first.
+func (d *BusDevice) CommandExecTimeoutPriority(timeout time.Duration, priority CommandPriority, payload []byte, response []byte) ([]byte, error) {
 	if d.isClosed() {
 		return nil, ErrorClosed
 	}
 
-	return d.controller.commandExecTimeout(timeout, d.address, d.address, payload, response)
+	ctx, cancel := d.closedContext(context.Background())
+	defer cancel(nil)
+
+	start := time.Now()
+	resp, err := d.controller.commandExecTimeout(ctx, timeout, priority, d.address, []uint8{d.address}, payload, response)
+	err = closedError(ctx, err)
+	d.stats.record(start, resp != nil && err == nil)
+	d.journal.record(d.controller.config.JournalSize, start, payload, resp, err)
+	if errors.Is(err, ErrorTimeout) && !d.controller.config.TimeoutErrors {
+		return nil, nil
+	}
+	return resp, err
 }
 
 // FunctionalDevice represents code implementing the interface to a BattGO compatible device.
 type FunctionalDevice interface {
 	// Access is called periodically by the controller. The module should perform periodic actions.
 	// If communications to the device failed, the boolean value should be false.
-	// If error is not nil, the controller Run() function will terminate with this error.
+	// If error is not nil, the OnDeviceError callback of the controller decides how to continue. By
+	// default the controller Run() function will terminate with this error.
 	Access() (bool, error)
 
 	// Disconnected will be called by the controller when it appears the device left the bus.
 	Disconnected() error
 }
 
+// ConnectingDevice is an optional interface for FunctionalDevices that need one-time initialization.
+// Connected is called after the device got its address and before the first call to Access(). Its
+// return values are handled like those of Access(). It is not called in monitor mode.
+type ConnectingDevice interface {
+	Connected() (bool, error)
+}
+
+// PacedDevice is an optional interface for FunctionalDevices that decide themselves how often they
+// are polled. When it is implemented, AccessNext is called instead of Access(). Its first and last
+// return values are handled like those of Access(). The duration is the minimum time until the
+// next call, it overrides the poll interval for this call only. When zero, the poll interval of
+// the device is used.
+type PacedDevice interface {
+	AccessNext() (bool, time.Duration, error)
+}
+
+// RemovedDevice is an optional interface for FunctionalDevices that hold resources, such as timers,
+// that must be released when the controller forgets the device. Removed is called after
+// Disconnected(), or when the device is evicted if an Eviction policy keeps it.
+type RemovedDevice interface {
+	Removed()
+}
+
 type dummyDevice struct {
 }
 
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceStats contains communication statistics of a single device.
+type DeviceStats struct {
+	// Commands is the number of commands sent to the device.
+	Commands uint64
+	// Responses is the number of commands that were answered.
+	Responses uint64
+	// Failures is the number of commands that timed out or failed.
+	Failures uint64
+
+	// LatencyAverage is the average time needed to execute a command, including waiting for the bus.
+	LatencyAverage time.Duration
+	// LatencyP50, LatencyP95 and LatencyP99 are percentiles over the most recent responses.
+	LatencyP50 time.Duration
+	LatencyP95 time.Duration
+	LatencyP99 time.Duration
+
+	// LastSeen is the time of the last response.
+	LastSeen time.Time
+}
+
+// SuccessRatio returns the fraction of commands that were answered.
+func (s DeviceStats) SuccessRatio() float64 {
+	if s.Commands == 0 {
+		return 0
+	}
+	return float64(s.Responses) / float64(s.Commands)
+}
+
+/* Number of latency samples kept for the percentiles */
+const deviceStatsSamples = 128
+
+type deviceStatsCounter struct {
+	sync.Mutex
+	stats DeviceStats
+
+	latencyTotal time.Duration
+	samples      [deviceStatsSamples]time.Duration
+	samplesNext  int
+	samplesCount int
+}
+
+func (s *deviceStatsCounter) record(start time.Time, ok bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.stats.Commands++
+	if !ok {
+		s.stats.Failures++
+		return
+	}
+
+	now := time.Now()
+	latency := now.Sub(start)
+
+	s.stats.Responses++
+	s.stats.LastSeen = now
+	s.latencyTotal += latency
+
+	s.samples[s.samplesNext] = latency
+	s.samplesNext = (s.samplesNext + 1) % deviceStatsSamples
+	if s.samplesCount < deviceStatsSamples {
+		s.samplesCount++
+	}
+}
+
+func (s *deviceStatsCounter) get() DeviceStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	if stats.Responses > 0 {
+		stats.LatencyAverage = s.latencyTotal / time.Duration(stats.Responses)
+	}
+
+	if s.samplesCount > 0 {
+		sorted := make([]time.Duration, s.samplesCount)
+		copy(sorted, s.samples[:s.samplesCount])
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i] < sorted[j]
+		})
+
+		percentile := func(p int) time.Duration {
+			return sorted[(len(sorted)-1)*p/100]
+		}
+		stats.LatencyP50 = percentile(50)
+		stats.LatencyP95 = percentile(95)
+		stats.LatencyP99 = percentile(99)
+	}
+
+	return stats
+}
+
+// Stats returns the communication statistics of the device.
+func (d *BusDevice) Stats() DeviceStats {
+	return d.stats.get()
+}
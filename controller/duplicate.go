@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	// ErrorDuplicateSerial is reported when two devices on the bus use the same serial.
+	ErrorDuplicateSerial = errors.New("Duplicate serial on the bus")
+)
+
+/* Called when a known serial answered the enumeration. Returns true if the known device still
+ * answers on its address, meaning that a second device uses the same serial. */
+func (c *Controller) duplicateCheck(dev *BusDevice) bool {
+	dev.Lock()
+	gone := dev.closed || dev.unconfirmed
+	dev.Unlock()
+	if gone {
+		return false
+	}
+
+	_, err := dev.CommandExecOptions(&CommandOptions{
+		Priority:    PriorityBackground,
+		ExpectReply: true,
+	}, []byte{cmdIdentify}, nil)
+	if err != nil {
+		/* The device lost its address, it will get it back */
+		return false
+	}
+
+	if !c.duplicatesReported[string(dev.serial)] {
+		c.duplicatesReported[string(dev.serial)] = true
+
+		c.log().Warn("Duplicate serial detected", "serial", hex.EncodeToString(dev.serial), "address", dev.address)
+		c.errors.add(dev.address, ErrorDuplicateSerial)
+		if c.OnDuplicateSerial != nil {
+			c.OnDuplicateSerial(dev.serial)
+		}
+	}
+
+	return true
+}
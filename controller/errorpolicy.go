@@ -0,0 +1,22 @@
+package controller
+
+// ErrorAction tells the controller how to proceed after a FunctionalDevice returned an error.
+type ErrorAction int
+
+const (
+	// ErrorActionStop makes Run() terminate with the error. This is the default.
+	ErrorActionStop ErrorAction = iota
+	// ErrorActionContinue ignores the error and keeps using the device.
+	ErrorActionContinue
+	// ErrorActionDisconnect handles the device as if it left the bus. It will be enumerated
+	// again when it answers the next scan.
+	ErrorActionDisconnect
+)
+
+func (c *Controller) deviceErrorAction(dev *BusDevice, err error) ErrorAction {
+	if c.OnDeviceError == nil {
+		return ErrorActionStop
+	}
+
+	return c.OnDeviceError(dev, err)
+}
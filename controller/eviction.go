@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// EvictionPolicy decides how long a device that left the bus is remembered. While a device is
+// stale it keeps its BusDevice, FunctionalDevice and address. When it answers an enumeration
+// before the timeout, it is attached again without creating a new FunctionalDevice, and
+// Connected() is called again if it implements ConnectingDevice. Disconnected() is called when
+// the device leaves, Removed() and OnDeviceRemoved only when it is evicted.
+type EvictionPolicy interface {
+	// StaleTimeout returns how long the device is kept after it left the bus. When zero, it is
+	// forgotten immediately.
+	StaleTimeout(device *BusDevice) time.Duration
+}
+
+// EvictAfter is an EvictionPolicy that keeps every device for the same time.
+type EvictAfter time.Duration
+
+// StaleTimeout implements EvictionPolicy.
+func (e EvictAfter) StaleTimeout(device *BusDevice) time.Duration {
+	return time.Duration(e)
+}
+
+type staleDevice struct {
+	device   *BusDevice
+	deadline time.Time
+}
+
+// StaleDevices returns the devices that left the bus but were not evicted yet.
+func (c *Controller) StaleDevices() []*BusDevice {
+	c.devicesMutex.Lock()
+	defer c.devicesMutex.Unlock()
+
+	result := make([]*BusDevice, 0, len(c.stale))
+	for _, s := range c.stale {
+		result = append(result, s.device)
+	}
+	return result
+}
+
+/* Moves a departed device to the stale list if the policy wants to keep it */
+func (c *Controller) staleAdd(dev *BusDevice) bool {
+	if c.Eviction == nil || c.monitor || dev.deviceNew || dev.Skipped() {
+		return false
+	}
+
+	timeout := c.Eviction.StaleTimeout(dev)
+	if timeout <= 0 {
+		return false
+	}
+
+	c.log().Info("Device stale", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "timeout", timeout)
+
+	c.devicesMutex.Lock()
+	delete(c.devices, dev.key)
+	c.stale[dev.key] = staleDevice{
+		device:   dev,
+		deadline: c.config.Clock.Now().Add(timeout),
+	}
+	c.devicesMutex.Unlock()
+	return true
+}
+
+/* Forgets stale devices whose timeout expired, or all of them */
+func (c *Controller) staleEvict(all bool) {
+	now := c.config.Clock.Now()
+	for key, s := range c.stale {
+		if !all && now.Before(s.deadline) {
+			continue
+		}
+
+		c.log().Info("Device evicted", "serial", hex.EncodeToString(s.device.serial), "address", s.device.address)
+
+		c.addressRelease(s.device.address)
+		c.devicesMutex.Lock()
+		delete(c.stale, key)
+		c.devicesMutex.Unlock()
+		c.deviceForget(s.device)
+	}
+}
+
+/* Returns a stale device to the list of known devices, or nil if there is none with this key */
+func (c *Controller) staleRevive(key string) *BusDevice {
+	c.devicesMutex.Lock()
+	defer c.devicesMutex.Unlock()
+
+	s, ok := c.stale[key]
+	if !ok {
+		return nil
+	}
+	delete(c.stale, key)
+	c.devices[key] = s.device
+
+	dev := s.device
+	dev.Lock()
+	dev.closed = false
+	dev.closedChan = nil
+	dev.failures = 0
+	dev.unconfirmed = false
+	dev.Unlock()
+
+	c.log().Info("Device returned", "serial", hex.EncodeToString(dev.serial), "address", dev.address)
+	return dev
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"bytes"
+	"sync"
+)
+
+type serialFactory struct {
+	prefix  []byte
+	factory func(device *BusDevice) FunctionalDevice
+}
+
+type deviceFactories struct {
+	sync.RWMutex
+	class  map[string]func(device *BusDevice) FunctionalDevice
+	serial []serialFactory
+}
+
+/* Returns the registered factory for a device, preferring the longest matching serial prefix */
+func (f *deviceFactories) lookup(dev *BusDevice) func(device *BusDevice) FunctionalDevice {
+	f.RLock()
+	defer f.RUnlock()
+
+	var best *serialFactory
+	for i, m := range f.serial {
+		if bytes.HasPrefix(dev.serial, m.prefix) && (best == nil || len(m.prefix) > len(best.prefix)) {
+			best = &f.serial[i]
+		}
+	}
+	if best != nil {
+		return best.factory
+	}
+
+	if len(f.class) > 0 {
+		if factory, ok := f.class[dev.GetClass()]; ok {
+			return factory
+		}
+	}
+	return nil
+}
+
+func (f *deviceFactories) needClass() bool {
+	f.RLock()
+	defer f.RUnlock()
+
+	return len(f.class) > 0
+}
+
+// RegisterClassFactory sets the function that creates the FunctionalDevice of devices with the
+// given class. It takes precedence over ClassFactories and may be called while Run() is active.
+// Only devices that are discovered afterwards are affected. Passing nil removes the factory.
+func (c *Controller) RegisterClassFactory(class string, factory func(device *BusDevice) FunctionalDevice) {
+	c.factories.Lock()
+	defer c.factories.Unlock()
+
+	if factory == nil {
+		delete(c.factories.class, class)
+		return
+	}
+
+	if c.factories.class == nil {
+		c.factories.class = make(map[string]func(device *BusDevice) FunctionalDevice)
+	}
+	c.factories.class[class] = factory
+}
+
+// RegisterSerialFactory sets the function that creates the FunctionalDevice of devices with a
+// serial starting with prefix. When multiple prefixes match the longest one is used. Serial
+// factories take precedence over class factories and may be registered while Run() is active.
+// Only devices that are discovered afterwards are affected. Passing nil removes the factory.
+func (c *Controller) RegisterSerialFactory(prefix []byte, factory func(device *BusDevice) FunctionalDevice) {
+	c.factories.Lock()
+	defer c.factories.Unlock()
+
+	for i, m := range c.factories.serial {
+		if bytes.Equal(m.prefix, prefix) {
+			c.factories.serial = append(c.factories.serial[:i], c.factories.serial[i+1:]...)
+			break
+		}
+	}
+
+	if factory != nil {
+		c.factories.serial = append(c.factories.serial, serialFactory{
+			prefix:  append([]byte(nil), prefix...),
+			factory: factory,
+		})
+	}
+}
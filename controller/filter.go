@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+func serialInList(list [][]byte, serial []byte) bool {
+	for _, s := range list {
+		if bytes.Equal(s, serial) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Controller) serialAllowed(serial []byte) bool {
+	if len(c.SerialAllowList) > 0 && !serialInList(c.SerialAllowList, serial) {
+		return false
+	}
+	return !serialInList(c.SerialDenyList, serial)
+}
+
+/* Creates the FunctionalDevice of a newly found device, unless it is filtered out. Returns an
+ * error if Run() should terminate. */
+func (c *Controller) deviceAttach(dev *BusDevice) error {
+	if !c.serialAllowed(dev.serial) {
+		c.log().Info("Device skipped by serial filter", "serial", hex.EncodeToString(dev.serial), "address", dev.address)
+		dev.Lock()
+		dev.skipped = true
+		dev.Unlock()
+		return nil
+	}
+
+	if len(c.ClassFactories) > 0 || c.factories.needClass() {
+		c.deviceIdentify(dev)
+	}
+
+	d := c.deviceFactory(dev)(dev)
+	if d == nil {
+		return nil
+	}
+
+	dev.Lock()
+	dev.device = d
+	dev.Unlock()
+
+	return c.deviceConnect(dev)
+}
+
+/* Calls Connected() of a device that implements ConnectingDevice. Returns an error if Run()
+ * should terminate. */
+func (c *Controller) deviceConnect(dev *BusDevice) error {
+	cd, ok := dev.GetDevice().(ConnectingDevice)
+	if !ok || c.monitor {
+		return nil
+	}
+
+	active, err := cd.Connected()
+	if err != nil {
+		c.log().Warn("Device initialization failed", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "error", err)
+		c.errors.add(dev.address, err)
+		switch c.deviceErrorAction(dev, err) {
+		case ErrorActionStop:
+			return err
+		case ErrorActionDisconnect:
+			active = false
+		}
+	}
+
+	if !active {
+		dev.close()
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package battery
+
+import (
+	"sync"
+	"time"
+)
+
+// Alarm is a set of alarm conditions of a battery.
+type Alarm int
+
+const (
+	// AlarmCellLow is active when a cell is below AlarmConfig.MinCellV.
+	AlarmCellLow Alarm = 1 << iota
+	// AlarmTemperatureHigh is active when the temperature is above AlarmConfig.MaxTempC.
+	AlarmTemperatureHigh
+	// AlarmImbalance is active when the cell spread is above AlarmConfig.MaxImbalanceV.
+	AlarmImbalance
+	// AlarmStale is active when the state was not read for AlarmConfig.StaleAfter. It is
+	// evaluated by a timer, so it also becomes active when the battery left the bus. The timer
+	// is stopped when the controller forgets the battery.
+	AlarmStale
+)
+
+const alarmMeasurements = AlarmCellLow | AlarmTemperatureHigh | AlarmImbalance
+
+// AlarmConfig contains the alarm thresholds of a battery. Thresholds that are zero are disabled.
+type AlarmConfig struct {
+	MinCellV      float32
+	MaxTempC      int
+	MaxImbalanceV float32
+	StaleAfter    time.Duration
+
+	// OnAlarm is called when an alarm becomes active or is cleared. It is called from the
+	// goroutine that runs the controller, or from a timer goroutine for AlarmStale. Calls are
+	// never concurrent.
+	OnAlarm func(battery *DeviceBattery, alarm Alarm, active bool)
+}
+
+type alarms struct {
+	sync.Mutex
+	config   AlarmConfig
+	active   Alarm
+	lastRead time.Time
+	timer    *time.Timer
+
+	/* Serializes the calls to OnAlarm */
+	report sync.Mutex
+}
+
+// SetAlarms sets the alarm thresholds of the battery. Passing nil disables all alarms.
+func (d *DeviceBattery) SetAlarms(config *AlarmConfig) {
+	d.alarms.Lock()
+	defer d.alarms.Unlock()
+
+	if config == nil {
+		config = &AlarmConfig{}
+	}
+	d.alarms.config = *config
+	d.alarms.active = 0
+	d.alarmsArmLocked(time.Now())
+}
+
+/* Starts the timer that raises AlarmStale, StaleAfter after the last read */
+func (d *DeviceBattery) alarmsArmLocked(now time.Time) {
+	if d.alarms.timer != nil {
+		d.alarms.timer.Stop()
+		d.alarms.timer = nil
+	}
+
+	staleAfter := d.alarms.config.StaleAfter
+	if staleAfter <= 0 || d.alarms.lastRead.IsZero() {
+		return
+	}
+
+	d.alarms.timer = time.AfterFunc(staleAfter-now.Sub(d.alarms.lastRead), func() {
+		d.alarmsCheck(time.Now(), false)
+	})
+}
+
+// Alarms returns the alarms that are currently active.
+func (d *DeviceBattery) Alarms() Alarm {
+	d.alarms.Lock()
+	defer d.alarms.Unlock()
+
+	return d.alarms.active
+}
+
+/* Evaluates the thresholds and calls OnAlarm for every alarm that changed. read is true
+ * when the state page was just read successfully. */
+func (d *DeviceBattery) alarmsCheck(now time.Time, read bool) {
+	d.alarms.report.Lock()
+	defer d.alarms.report.Unlock()
+
+	d.Data.RLock()
+	cellMin := d.Data.CellMinV
+	spread := d.Data.CellSpreadV
+	temp := d.Data.TempCurrentC
+	cells := len(d.Data.CellVoltageV)
+	d.Data.RUnlock()
+
+	d.alarms.Lock()
+	config := d.alarms.config
+	if read || d.alarms.lastRead.IsZero() {
+		d.alarms.lastRead = now
+		d.alarmsArmLocked(now)
+	}
+
+	var active Alarm
+	if cells > 0 {
+		if config.MinCellV > 0 && cellMin < config.MinCellV {
+			active |= AlarmCellLow
+		}
+		if config.MaxTempC != 0 && temp > config.MaxTempC {
+			active |= AlarmTemperatureHigh
+		}
+		if config.MaxImbalanceV > 0 && spread > config.MaxImbalanceV {
+			active |= AlarmImbalance
+		}
+	}
+	if config.StaleAfter > 0 && now.Sub(d.alarms.lastRead) >= config.StaleAfter {
+		active |= AlarmStale
+	}
+
+	d.alarmsSetLocked(config, active)
+}
+
+/* Clears the alarms that depend on the measurements, which are no longer valid once the battery
+ * left the bus. AlarmStale is still raised by the timer. */
+func (d *DeviceBattery) alarmsDisconnected() {
+	d.alarms.report.Lock()
+	defer d.alarms.report.Unlock()
+
+	d.alarms.Lock()
+	d.alarmsSetLocked(d.alarms.config, d.alarms.active&^alarmMeasurements)
+}
+
+/* Stops the AlarmStale timer, the battery is no longer known to the controller */
+func (d *DeviceBattery) alarmsRemoved() {
+	d.alarms.Lock()
+	defer d.alarms.Unlock()
+
+	if d.alarms.timer != nil {
+		d.alarms.timer.Stop()
+		d.alarms.timer = nil
+	}
+}
+
+/* Stores the active alarms, unlocks d.alarms and calls OnAlarm for every alarm that changed */
+func (d *DeviceBattery) alarmsSetLocked(config AlarmConfig, active Alarm) {
+	changed := active ^ d.alarms.active
+	d.alarms.active = active
+	d.alarms.Unlock()
+
+	if config.OnAlarm == nil {
+		return
+	}
+	for alarm := AlarmCellLow; alarm <= AlarmStale; alarm <<= 1 {
+		if changed&alarm != 0 {
+			config.OnAlarm(d, alarm, active&alarm != 0)
+		}
+	}
+}
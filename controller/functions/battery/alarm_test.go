@@ -0,0 +1,142 @@
+package battery_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/controller"
+	"github.com/BertoldVdb/go-battgo/controller/functions/battery"
+)
+
+/* statePage returns the answer to a state page read */
+func statePage(tempC int8, cellsMV ...uint16) []byte {
+	page := []byte{battery.OpcodeReadState + 1, 0, byte(len(cellsMV) - 1)}
+	for _, m := range cellsMV {
+		page = binary.LittleEndian.AppendUint16(page, m)
+	}
+	return append(page, byte(tempC))
+}
+
+type alarmEvent struct {
+	alarm  battery.Alarm
+	active bool
+}
+
+func newAlarmBattery(t *testing.T, config battery.AlarmConfig) (*battery.DeviceBattery, chan (alarmEvent)) {
+	d := battery.New(&controller.BusDevice{}, nil).(*battery.DeviceBattery)
+
+	events := make(chan (alarmEvent), 16)
+	config.OnAlarm = func(b *battery.DeviceBattery, alarm battery.Alarm, active bool) {
+		if b != d {
+			t.Errorf("OnAlarm called for another battery")
+		}
+		events <- alarmEvent{alarm, active}
+	}
+	d.SetAlarms(&config)
+	t.Cleanup(d.Removed)
+
+	return d, events
+}
+
+func expectAlarms(t *testing.T, events chan (alarmEvent), expected ...alarmEvent) {
+	t.Helper()
+
+	for _, e := range expected {
+		select {
+		case got := <-events:
+			if got != e {
+				t.Fatalf("Received alarm %d active=%v, expected %d active=%v", got.alarm, got.active, e.alarm, e.active)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Alarm %d active=%v not reported", e.alarm, e.active)
+		}
+	}
+
+	select {
+	case got := <-events:
+		t.Fatalf("Unexpected alarm %d active=%v", got.alarm, got.active)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestAlarmThresholds(t *testing.T) {
+	d, events := newAlarmBattery(t, battery.AlarmConfig{
+		MinCellV:      3.5,
+		MaxTempC:      50,
+		MaxImbalanceV: 0.1,
+	})
+
+	d.Observe(statePage(25, 3600, 3650))
+	expectAlarms(t, events)
+
+	d.Observe(statePage(25, 3400, 3650))
+	expectAlarms(t, events, alarmEvent{battery.AlarmCellLow, true}, alarmEvent{battery.AlarmImbalance, true})
+	if alarms := d.Alarms(); alarms != battery.AlarmCellLow|battery.AlarmImbalance {
+		t.Fatalf("Active alarms are %d", alarms)
+	}
+
+	/* Unchanged alarms are not reported again */
+	d.Observe(statePage(25, 3450, 3650))
+	expectAlarms(t, events)
+
+	d.Observe(statePage(60, 3600, 3650))
+	expectAlarms(t, events, alarmEvent{battery.AlarmCellLow, false}, alarmEvent{battery.AlarmTemperatureHigh, true}, alarmEvent{battery.AlarmImbalance, false})
+	if alarms := d.Alarms(); alarms != battery.AlarmTemperatureHigh {
+		t.Fatalf("Active alarms are %d", alarms)
+	}
+
+	/* Measurement alarms are cleared when the battery leaves */
+	d.Disconnected()
+	expectAlarms(t, events, alarmEvent{battery.AlarmTemperatureHigh, false})
+}
+
+func TestAlarmDisabled(t *testing.T) {
+	d, events := newAlarmBattery(t, battery.AlarmConfig{})
+
+	d.Observe(statePage(100, 2000, 4000))
+	expectAlarms(t, events)
+
+	d.SetAlarms(nil)
+	d.Observe(statePage(100, 2000, 4100))
+	if alarms := d.Alarms(); alarms != 0 {
+		t.Fatalf("Active alarms are %d", alarms)
+	}
+}
+
+func TestAlarmStale(t *testing.T) {
+	d, events := newAlarmBattery(t, battery.AlarmConfig{
+		StaleAfter: 50 * time.Millisecond,
+	})
+
+	d.Observe(statePage(25, 3600))
+	expectAlarms(t, events)
+
+	/* Raised once by the timer, which is not armed again until the next read */
+	time.Sleep(100 * time.Millisecond)
+	expectAlarms(t, events, alarmEvent{battery.AlarmStale, true})
+	time.Sleep(150 * time.Millisecond)
+	expectAlarms(t, events)
+
+	d.Observe(statePage(25, 3600))
+	expectAlarms(t, events, alarmEvent{battery.AlarmStale, false})
+
+	/* Also raised after the battery left the bus */
+	d.Disconnected()
+	time.Sleep(100 * time.Millisecond)
+	expectAlarms(t, events, alarmEvent{battery.AlarmStale, true})
+}
+
+func TestAlarmStaleRemoved(t *testing.T) {
+	d, events := newAlarmBattery(t, battery.AlarmConfig{
+		StaleAfter: 50 * time.Millisecond,
+	})
+
+	d.Observe(statePage(25, 3600))
+	d.Disconnected()
+	d.Removed()
+
+	/* The timer of a battery the controller forgot does not fire */
+	time.Sleep(100 * time.Millisecond)
+	expectAlarms(t, events)
+}
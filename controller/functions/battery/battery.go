@@ -3,6 +3,7 @@ package battery
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"sync"
 	"time"
 
@@ -28,6 +29,10 @@ type BatteryData struct {
 	Connected bool
 	LastData  time.Time
 
+	// Restored is true when the data was restored from a Store and the state was not read from
+	// the battery yet.
+	Restored bool
+
 	BusAddress       uint8
 	Serial           string
 	ManufacturerName string
@@ -60,6 +65,28 @@ type BatteryData struct {
 
 	TempCurrentC int
 	CellVoltageV []float32
+
+	// The values below are derived from CellVoltageV. InStorageBand is true when all cells are
+	// within 50 mV of the preferred, or otherwise the default, storage voltage.
+	PackVoltageV  float32
+	CellMinV      float32
+	CellMaxV      float32
+	CellSpreadV   float32
+	InStorageBand bool
+
+	// SoCPercent is the state of charge estimated from the average cell voltage, or -1 if the
+	// chemistry is not known. The estimate assumes the voltage of a resting battery, it is only
+	// meaningful when SoCResting is true, which means the pack voltage did not change by more
+	// than 10 mV for a minute. Under load or while charging the estimate is wrong.
+	SoCPercent float32
+	SoCResting bool
+
+	socRestV     float32
+	socRestSince time.Time
+
+	FirmwareVersion int
+	HardwareVersion int
+	ProtocolVersion int
 }
 
 type DeviceBattery struct {
@@ -70,11 +97,19 @@ type DeviceBattery struct {
 	serial       []byte
 	factoryInfo  []byte
 	userSettings []byte
+	versionInfo  []byte
 
 	Data       BatteryData
 	updateChan chan<- (*DeviceBattery)
 
-	readIndex int
+	subscriptions subscriptions
+	alarms        alarms
+	history       history
+
+	store     Store
+	storeLast time.Time
+
+	poll pollPlan
 }
 
 // New creates a device representing a standard BattGO compatible battery. When the internal data is updated,
@@ -88,6 +123,7 @@ func New(device *controller.BusDevice, updateChan chan<- (*DeviceBattery)) contr
 	d.Data.Serial = hex.EncodeToString(device.GetSerial())
 	d.Data.BusAddress = device.GetAddress()
 	d.Data.Connected = true
+	d.Data.SoCPercent = -1
 
 	return d
 }
@@ -133,6 +169,23 @@ func (d *DeviceBattery) deltaFactoryData() (bool, error) {
 	d.Data.TempStorageHighC = int(int8(d.factoryInfo[21]))
 	d.Data.BatteryHasAutoDischarge = d.factoryInfo[22] > 0
 	d.Data.BatteryNumberOfCells = int(d.factoryInfo[23])
+	d.Data.updateDerived()
+
+	return true, nil
+}
+
+/* The opcode and layout of the version page are not confirmed. It is assumed to contain the
+ * firmware, hardware and protocol version, one byte each. */
+func (d *DeviceBattery) deltaVersion() (bool, error) {
+	if len(d.versionInfo) < 4 {
+		return false, nil
+	}
+
+	d.Data.Lock()
+	defer d.Data.Unlock()
+	d.Data.FirmwareVersion = int(d.versionInfo[1])
+	d.Data.HardwareVersion = int(d.versionInfo[2])
+	d.Data.ProtocolVersion = int(d.versionInfo[3])
 
 	return true, nil
 }
@@ -149,6 +202,7 @@ func (d *DeviceBattery) deltaUser() (bool, error) {
 	d.Data.CellPreferredMaxVoltageV = float32(binary.LittleEndian.Uint16(d.userSettings[6:8])) / 1000.0
 	d.Data.BatterySelfDischargeEnabled = d.userSettings[8] != 0xFF
 	d.Data.BatterySelfDischargeHours = int(d.userSettings[8])
+	d.Data.updateDerived()
 
 	return true, nil
 }
@@ -192,22 +246,53 @@ func (d *DeviceBattery) deltaState() (bool, error) {
 	}
 
 	d.Data.TempCurrentC = int(int8(d.currentState[index]))
+	d.Data.updateDerived()
 
 	d.Data.LastData = time.Now()
 
 	return true, nil
 }
 
+/* Called after every successful read of the state page */
+func (d *DeviceBattery) stateRead() {
+	now := time.Now()
+
+	d.Data.Lock()
+	resting := d.Data.SoCResting
+	d.Data.updateResting(now)
+	changed := resting != d.Data.SoCResting
+	d.Data.Restored = false
+	d.Data.Unlock()
+
+	if changed {
+		d.notify(FieldCellVoltages)
+	}
+	d.historyRecord(now)
+	d.storeSave(now, false)
+}
+
 func (d *DeviceBattery) readData(cmd []byte, expectedReply uint8, destination *[]byte, deltaFunc func() (bool, error)) (bool, error) {
 	var rxBuf [256]byte
 
-	response, err := d.parent.CommandExecTimeout(0, cmd, rxBuf[:])
-	if response == nil {
+	response, err := d.parent.CommandExecOptions(&controller.CommandOptions{
+		Priority: controller.PriorityBackground,
+		Validate: func(response []byte) bool {
+			return len(response) > 0 && response[0] == expectedReply
+		},
+	}, cmd, rxBuf[:])
+	if errors.Is(err, controller.ErrorTimeout) || errors.Is(err, controller.ErrorBadResponse) {
+		/* The device did not answer properly, this is not fatal */
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 
+	return d.storeData(response, expectedReply, destination, deltaFunc)
+}
+
+func (d *DeviceBattery) storeData(response []byte, expectedReply uint8, destination *[]byte, deltaFunc func() (bool, error)) (bool, error) {
 	if len(response) == 0 || response[0] != expectedReply {
-		return false, err
+		return false, nil
 	}
 
 	if !bytes.Equal(response, *destination) {
@@ -219,11 +304,19 @@ func (d *DeviceBattery) readData(cmd []byte, expectedReply uint8, destination *[
 		*destination = cpy
 
 		if deltaFunc != nil {
-			return deltaFunc()
+			if !d.subscribed() {
+				return deltaFunc()
+			}
+
+			before := d.Snapshot()
+			ok, err := deltaFunc()
+			after := d.Snapshot()
+			d.notify(fieldsChanged(&before, &after))
+			return ok, err
 		}
 	}
 
-	return true, err
+	return true, nil
 }
 
 func (d *DeviceBattery) signalUpdate() {
@@ -235,45 +328,117 @@ func (d *DeviceBattery) signalUpdate() {
 
 // Access is an internal function that should only be called by the controller.
 func (d *DeviceBattery) Access() (bool, error) {
-	d.readIndex++
-	switch d.readIndex {
-	case 0:
+	page, ok := d.pollNext()
+	if !ok {
+		/* Nothing is due, still read the state so a removed battery is noticed */
+		page = PageState
+	}
+
+	ok, err := d.readPage(page)
+	d.pollDone(page, ok)
+	return ok, err
+}
+
+/* Reads a single page and updates the data */
+func (d *DeviceBattery) readPage(page Page) (bool, error) {
+	switch page {
+	case PageState:
 		numCell := d.Data.BatteryNumberOfCells
 		if numCell == 0 {
 			numCell = 8
 		}
-		ok, err := d.readData([]byte{0x44, 0, byte(d.Data.BatteryNumberOfCells - 1)}, 0x45, &d.currentState, d.deltaState)
+		ok, err := d.readData([]byte{OpcodeReadState, 0, byte(d.Data.BatteryNumberOfCells - 1)}, OpcodeReadState+1, &d.currentState, d.deltaState)
+		if ok {
+			d.stateRead()
+		}
+		d.alarmsCheck(time.Now(), ok)
 
 		d.signalUpdate()
 
 		return ok, err
-	case 1:
-		return d.readData([]byte{0x4A}, 0x4B, &d.cycleInfo, d.deltaCycle)
-	case 2:
-		return d.readData([]byte{0x42}, 0x43, &d.userSettings, d.deltaUser)
-	case 3:
-		return d.readData([]byte{0x84}, 0x85, &d.serial, d.deltaSerial)
-	default:
-		d.readIndex = -1
-		return d.readData([]byte{0x88}, 0x89, &d.factoryInfo, d.deltaFactoryData)
+	case PageCycle:
+		return d.readData([]byte{OpcodeReadCycle}, OpcodeReadCycle+1, &d.cycleInfo, d.deltaCycle)
+	case PageUser:
+		return d.readData([]byte{OpcodeReadUser}, OpcodeReadUser+1, &d.userSettings, d.deltaUser)
+	case PageSerial:
+		return d.readData([]byte{OpcodeReadSerial}, OpcodeReadSerial+1, &d.serial, d.deltaSerial)
+	case PageVersion:
+		return d.readData([]byte{OpcodeReadVersion}, OpcodeReadVersion+1, &d.versionInfo, d.deltaVersion)
+	case PageFactory:
+		return d.readData([]byte{OpcodeReadFactory}, OpcodeReadFactory+1, &d.factoryInfo, d.deltaFactoryData)
+	}
+	return true, nil
+}
+
+// Observe is an internal function that should only be called by a controller in monitor mode.
+func (d *DeviceBattery) Observe(response []byte) {
+	if len(response) == 0 {
+		return
+	}
+
+	switch response[0] {
+	case OpcodeReadState + 1:
+		ok, _ := d.storeData(response, OpcodeReadState+1, &d.currentState, d.deltaState)
+		if ok {
+			d.stateRead()
+		}
+		d.alarmsCheck(time.Now(), ok)
+		d.signalUpdate()
+	case OpcodeReadCycle + 1:
+		d.storeData(response, OpcodeReadCycle+1, &d.cycleInfo, d.deltaCycle)
+	case OpcodeReadUser + 1:
+		d.storeData(response, OpcodeReadUser+1, &d.userSettings, d.deltaUser)
+	case OpcodeReadSerial + 1:
+		d.storeData(response, OpcodeReadSerial+1, &d.serial, d.deltaSerial)
+	case OpcodeReadVersion + 1:
+		d.storeData(response, OpcodeReadVersion+1, &d.versionInfo, d.deltaVersion)
+	case OpcodeReadFactory + 1:
+		d.storeData(response, OpcodeReadFactory+1, &d.factoryInfo, d.deltaFactoryData)
+	}
+}
+
+// Connected is an internal function that should only be called by the controller. It marks the
+// battery as connected again when it returns after Disconnected().
+func (d *DeviceBattery) Connected() (bool, error) {
+	d.Data.Lock()
+	changed := !d.Data.Connected
+	d.Data.Connected = true
+	d.Data.BusAddress = d.parent.GetAddress()
+	d.Data.Unlock()
+
+	if changed {
+		d.notify(FieldConnection)
+		d.signalUpdate()
 	}
+	return true, nil
 }
 
 // Disconnected is an internal function that should only be called by the controller.
 func (d *DeviceBattery) Disconnected() error {
 	d.Data.Lock()
+	changed := d.Data.Connected
 	d.Data.Connected = false
 	d.Data.Unlock()
 
+	if changed {
+		d.notify(FieldConnection)
+	}
+	d.alarmsDisconnected()
+	d.storeSave(time.Now(), true)
 	d.signalUpdate()
 	return nil
 }
 
+// Removed is an internal function that should only be called by the controller.
+func (d *DeviceBattery) Removed() {
+	d.alarmsRemoved()
+}
+
 // SetConfiguration will write a new configuration to the battery.
 // Self discharge is disabled when dischargeHours is negative.
 func (d *DeviceBattery) SetConfiguration(chargeCurrentA float32, storageVoltageV float32, maxVoltageV float32, dischargeHours float32) (bool, error) {
 	var buf [9]byte
-	buf[0] = 0x46
+	buf[0] = OpcodeWriteUser
 	binary.LittleEndian.PutUint32(buf[1:], uint32(chargeCurrentA*1000))
 	binary.LittleEndian.PutUint16(buf[4:], uint16(storageVoltageV*1000))
 	binary.LittleEndian.PutUint16(buf[6:], uint16(maxVoltageV*1000))
@@ -283,10 +448,24 @@ func (d *DeviceBattery) SetConfiguration(chargeCurrentA float32, storageVoltageV
 		buf[8] = uint8(dischargeHours)
 	}
 
-	response, err := d.parent.CommandExecTimeout(time.Second, buf[:], nil)
-	if err != nil {
+	return d.writeData(buf[:])
+}
+
+/* Sends a command that changes settings of the battery, which answers with a short acknowledgement */
+func (d *DeviceBattery) writeData(cmd []byte) (bool, error) {
+	_, err := d.parent.CommandExecOptions(&controller.CommandOptions{
+		Timeout:     time.Second,
+		Priority:    controller.PriorityInteractive,
+		ExpectReply: true,
+		Validate: func(response []byte) bool {
+			return len(response) == 2
+		},
+	}, cmd, nil)
+	if errors.Is(err, controller.ErrorTimeout) || errors.Is(err, controller.ErrorBadResponse) {
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 
-	return len(response) == 2 && response[0] == 0x47, nil
+	return true, nil
 }
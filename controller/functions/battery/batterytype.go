@@ -0,0 +1,104 @@
+package battery
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrorUnknownBatteryType is returned by ParseBatteryType for names that are not known.
+	ErrorUnknownBatteryType = errors.New("Unknown battery type")
+	// ErrorDuplicateBatteryType is returned by RegisterBatteryType when another type already uses the name.
+	ErrorDuplicateBatteryType = errors.New("Battery type name is already used")
+)
+
+// ChemistryVoltages contains the reference cell voltages of a chemistry.
+type ChemistryVoltages struct {
+	MinV     float32
+	NominalV float32
+	StorageV float32
+	MaxV     float32
+}
+
+type chemistry struct {
+	name     string
+	voltages ChemistryVoltages
+}
+
+var (
+	chemistriesMutex sync.RWMutex
+	chemistries      = map[BatteryType]chemistry{
+		BatteryTypeLiHv:  {"LiHV", ChemistryVoltages{MinV: 3.0, NominalV: 3.8, StorageV: 3.85, MaxV: 4.35}},
+		BatteryTypeLiPo:  {"LiPo", ChemistryVoltages{MinV: 3.0, NominalV: 3.7, StorageV: 3.8, MaxV: 4.2}},
+		BatteryTypeLiIon: {"LiIon", ChemistryVoltages{MinV: 2.75, NominalV: 3.6, StorageV: 3.75, MaxV: 4.2}},
+		BatteryTypeLiFe:  {"LiFe", ChemistryVoltages{MinV: 2.5, NominalV: 3.3, StorageV: 3.3, MaxV: 3.6}},
+		BatteryTypePb:    {"Pb", ChemistryVoltages{MinV: 1.75, NominalV: 2.0, StorageV: 2.25, MaxV: 2.4}},
+		BatteryTypeNiMH:  {"NiMH", ChemistryVoltages{MinV: 1.0, NominalV: 1.2, StorageV: 1.2, MaxV: 1.5}},
+	}
+)
+
+// RegisterBatteryType adds a name and reference voltages for a raw battery type value that is not
+// one of the constants, or replaces those of a known type. Names are compared case insensitive, as
+// by ParseBatteryType, and must not be used by another type.
+func RegisterBatteryType(t BatteryType, name string, voltages ChemistryVoltages) error {
+	chemistriesMutex.Lock()
+	defer chemistriesMutex.Unlock()
+
+	for other, c := range chemistries {
+		if other != t && strings.EqualFold(c.name, name) {
+			return ErrorDuplicateBatteryType
+		}
+	}
+
+	chemistries[t] = chemistry{name: name, voltages: voltages}
+	return nil
+}
+
+// String returns the name of the chemistry. Unknown values are returned as Unknown(n), which
+// ParseBatteryType accepts as well, so the raw value is never lost.
+func (t BatteryType) String() string {
+	chemistriesMutex.RLock()
+	defer chemistriesMutex.RUnlock()
+
+	if c, ok := chemistries[t]; ok {
+		return c.name
+	}
+	return fmt.Sprintf("Unknown(%d)", int(t))
+}
+
+// Voltages returns the reference cell voltages of the chemistry, or false if they are not known.
+func (t BatteryType) Voltages() (ChemistryVoltages, bool) {
+	chemistriesMutex.RLock()
+	defer chemistriesMutex.RUnlock()
+
+	c, ok := chemistries[t]
+	return c.voltages, ok
+}
+
+// ParseBatteryType converts a name returned by BatteryType.String(), case insensitive, or a raw
+// number to a BatteryType.
+func ParseBatteryType(s string) (BatteryType, error) {
+	s = strings.TrimSpace(s)
+
+	chemistriesMutex.RLock()
+	for t, c := range chemistries {
+		if strings.EqualFold(c.name, s) {
+			chemistriesMutex.RUnlock()
+			return t, nil
+		}
+	}
+	chemistriesMutex.RUnlock()
+
+	raw := s
+	if lower := strings.ToLower(s); strings.HasPrefix(lower, "unknown(") && strings.HasSuffix(lower, ")") {
+		raw = s[len("unknown(") : len(s)-1]
+	}
+	if n, err := strconv.ParseUint(raw, 0, 8); err == nil {
+		return BatteryType(n), nil
+	}
+
+	return 0, ErrorUnknownBatteryType
+}
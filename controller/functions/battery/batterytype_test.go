@@ -0,0 +1,74 @@
+package battery_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/BertoldVdb/go-battgo/controller/functions/battery"
+)
+
+func TestBatteryTypeRoundTrip(t *testing.T) {
+	for _, bt := range []battery.BatteryType{
+		battery.BatteryTypeLiPo,
+		battery.BatteryTypeLiHv,
+		battery.BatteryTypeLiIon,
+		battery.BatteryTypeLiFe,
+		battery.BatteryTypeNiMH,
+		battery.BatteryTypePb,
+		battery.BatteryType(0),
+		battery.BatteryType(0xEF),
+		battery.BatteryType(0xFF),
+	} {
+		parsed, err := battery.ParseBatteryType(bt.String())
+		if err != nil || parsed != bt {
+			t.Errorf("%s parsed as %d, %v, expected %d", bt, parsed, err, bt)
+		}
+	}
+
+	if s := battery.BatteryType(0xEF).String(); s != "Unknown(239)" {
+		t.Errorf("Unregistered type is %s, expected Unknown(239)", s)
+	}
+
+	for _, s := range []string{"lipo", " LIPO ", "unknown(239)", "0xef"} {
+		if _, err := battery.ParseBatteryType(s); err != nil {
+			t.Errorf("Cannot parse %q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "Unknown", "Unknown(256)", "LiPoly"} {
+		if _, err := battery.ParseBatteryType(s); !errors.Is(err, battery.ErrorUnknownBatteryType) {
+			t.Errorf("Parsing %q returned %v, expected ErrorUnknownBatteryType", s, err)
+		}
+	}
+}
+
+func TestRegisterBatteryType(t *testing.T) {
+	custom := battery.BatteryType(0xF0)
+	voltages := battery.ChemistryVoltages{MinV: 2.0, NominalV: 2.3, StorageV: 2.4, MaxV: 2.7}
+
+	if err := battery.RegisterBatteryType(custom, "LTO", voltages); err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := battery.ParseBatteryType(custom.String()); err != nil || parsed != custom || custom.String() != "LTO" {
+		t.Fatalf("%s parsed as %d, %v", custom, parsed, err)
+	}
+	if v, ok := custom.Voltages(); !ok || v != voltages {
+		t.Fatalf("Voltages are %v, %v", v, ok)
+	}
+
+	/* Registering a type again may keep or change its name */
+	if err := battery.RegisterBatteryType(custom, "lto", voltages); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"LiPo", "lipo", "NIMH"} {
+		if err := battery.RegisterBatteryType(custom, name, voltages); !errors.Is(err, battery.ErrorDuplicateBatteryType) {
+			t.Errorf("Registering %q returned %v, expected ErrorDuplicateBatteryType", name, err)
+		}
+	}
+	if err := battery.RegisterBatteryType(battery.BatteryType(0xF1), "LTO", voltages); !errors.Is(err, battery.ErrorDuplicateBatteryType) {
+		t.Errorf("Registering a second LTO returned %v, expected ErrorDuplicateBatteryType", err)
+	}
+	if custom.String() != "lto" {
+		t.Errorf("Rejected registrations changed the name to %s", custom)
+	}
+}
@@ -0,0 +1,41 @@
+package battery
+
+/* Largest difference between a cell voltage and the storage voltage for the pack to be
+ * considered in the storage band */
+const storageBandV = 0.05
+
+/* Recomputes the values that are derived from the cell voltages, must be called with the lock held */
+func (b *BatteryData) updateDerived() {
+	b.PackVoltageV = 0
+	b.CellMinV = 0
+	b.CellMaxV = 0
+	b.CellSpreadV = 0
+	b.InStorageBand = false
+	b.SoCPercent = -1
+
+	if len(b.CellVoltageV) == 0 {
+		return
+	}
+
+	b.CellMinV = b.CellVoltageV[0]
+	b.CellMaxV = b.CellVoltageV[0]
+	for _, v := range b.CellVoltageV {
+		b.PackVoltageV += v
+		if v < b.CellMinV {
+			b.CellMinV = v
+		}
+		if v > b.CellMaxV {
+			b.CellMaxV = v
+		}
+	}
+	b.CellSpreadV = b.CellMaxV - b.CellMinV
+	b.SoCPercent = socEstimate(b.BatteryType, b.PackVoltageV/float32(len(b.CellVoltageV)))
+
+	storage := b.CellPreferredStorageVoltageV
+	if storage == 0 {
+		storage = b.CellStorageDefaultV
+	}
+	if storage > 0 {
+		b.InStorageBand = b.CellMinV >= storage-storageBandV && b.CellMaxV <= storage+storageBandV
+	}
+}
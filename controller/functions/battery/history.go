@@ -0,0 +1,97 @@
+package battery
+
+import (
+	"sync"
+	"time"
+)
+
+// HistorySample is a measurement stored in the history of a battery.
+type HistorySample struct {
+	Time         time.Time
+	CellVoltageV []float32
+	TempC        int
+}
+
+type history struct {
+	sync.Mutex
+
+	size    int
+	samples []HistorySample
+	next    int
+}
+
+// SetHistorySize enables the history of state samples and sets the number of samples that are
+// kept. A sample is taken after every successful read of the state page. When size is 0 the
+// history is disabled and cleared.
+func (d *DeviceBattery) SetHistorySize(size int) {
+	d.history.Lock()
+	defer d.history.Unlock()
+
+	if size < 0 {
+		size = 0
+	}
+
+	old := d.historyLocked(time.Time{}, time.Time{})
+	if len(old) > size {
+		old = old[len(old)-size:]
+	}
+
+	d.history.size = size
+	d.history.samples = old
+	d.history.next = 0
+}
+
+// History returns the samples taken between from and to, inclusive, oldest first. A zero from or
+// to leaves that side of the range open.
+func (d *DeviceBattery) History(from time.Time, to time.Time) []HistorySample {
+	d.history.Lock()
+	defer d.history.Unlock()
+
+	return d.historyLocked(from, to)
+}
+
+func (d *DeviceBattery) historyLocked(from time.Time, to time.Time) []HistorySample {
+	h := &d.history
+
+	var result []HistorySample
+	add := func(list []HistorySample) {
+		for _, m := range list {
+			if !from.IsZero() && m.Time.Before(from) {
+				continue
+			}
+			if !to.IsZero() && m.Time.After(to) {
+				continue
+			}
+			result = append(result, m)
+		}
+	}
+	add(h.samples[h.next:])
+	add(h.samples[:h.next])
+	return result
+}
+
+/* Stores a sample of the current state, must be called without the data lock held */
+func (d *DeviceBattery) historyRecord(now time.Time) {
+	d.history.Lock()
+	defer d.history.Unlock()
+
+	h := &d.history
+	if h.size == 0 {
+		return
+	}
+
+	d.Data.RLock()
+	sample := HistorySample{
+		Time:         now,
+		CellVoltageV: append([]float32(nil), d.Data.CellVoltageV...),
+		TempC:        d.Data.TempCurrentC,
+	}
+	d.Data.RUnlock()
+
+	if len(h.samples) < h.size {
+		h.samples = append(h.samples, sample)
+		return
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % h.size
+}
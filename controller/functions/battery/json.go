@@ -0,0 +1,14 @@
+package battery
+
+import "encoding/json"
+
+// MarshalJSON encodes the data using snake_case field names, see BatterySnapshot. It takes the
+// read lock, so the caller must not hold the write lock. Pass a pointer to json.Marshal, a
+// BatteryData value does not use this method.
+func (b *BatteryData) MarshalJSON() ([]byte, error) {
+	b.RLock()
+	v := b.snapshotLocked()
+	b.RUnlock()
+
+	return json.Marshal(&v)
+}
@@ -0,0 +1,33 @@
+package battery
+
+import "github.com/BertoldVdb/go-battgo/controller"
+
+// Opcodes of the pages of a battery. The answer to a command uses the opcode plus one.
+// OpcodeReadVersion is not confirmed, see PageVersion.
+const (
+	OpcodeReadUser    = 0x42
+	OpcodeReadState   = 0x44
+	OpcodeWriteUser   = 0x46
+	OpcodeReadCycle   = 0x4A
+	OpcodeReadSerial  = 0x84
+	OpcodeReadVersion = 0x86
+	OpcodeReadFactory = 0x88
+)
+
+// ReadPage sends a read command with optional arguments and returns the answer, including the
+// opcode in the first byte. It returns controller.ErrorTimeout if the battery did not answer and
+// controller.ErrorBadResponse if the answer did not use opcode+1. The data of the battery is not
+// updated.
+func (d *DeviceBattery) ReadPage(opcode byte, args ...byte) ([]byte, error) {
+	return d.parent.CommandExecOptions(&controller.CommandOptions{
+		Priority:    controller.PriorityInteractive,
+		ExpectReply: true,
+		Retries:     1,
+	}, append([]byte{opcode}, args...), nil)
+}
+
+// WritePage sends a write command with the given payload. It returns false if the battery did not
+// acknowledge it.
+func (d *DeviceBattery) WritePage(opcode byte, payload []byte) (bool, error) {
+	return d.writeData(append([]byte{opcode}, payload...))
+}
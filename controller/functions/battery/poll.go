@@ -0,0 +1,140 @@
+package battery
+
+import "sync"
+
+// Page is a page of battery data that is read by the poll plan.
+type Page int
+
+const (
+	// PageState contains the cell voltages and the temperature.
+	PageState Page = iota
+	// PageCycle contains the charge cycle and error counters.
+	PageCycle
+	// PageUser contains the settings written by SetConfiguration().
+	PageUser
+	// PageSerial contains the serial and the manufacturer name.
+	PageSerial
+	// PageVersion contains the firmware, hardware and protocol version. The opcode and layout of
+	// this page are not confirmed and not every battery answers it, so it is not part of the
+	// default plans. Batteries that do not answer are treated like any other failed read.
+	PageVersion
+	// PageFactory contains the battery type, limits and number of cells.
+	PageFactory
+)
+
+// PollEntry describes how often a page is read.
+type PollEntry struct {
+	Page Page
+
+	// Every is the number of rounds through the plan between two reads of the page. When 0 or 1
+	// the page is read every round.
+	Every int
+
+	// Once stops reading the page after it was read successfully.
+	Once bool
+}
+
+// DefaultPollPlan reads all pages except PageVersion in turn, one page per call to Access().
+var DefaultPollPlan = []PollEntry{
+	{Page: PageState},
+	{Page: PageCycle},
+	{Page: PageUser},
+	{Page: PageSerial},
+	{Page: PageFactory},
+}
+
+// EfficientPollPlan reads the state every round, the counters and settings every tenth round and
+// the static pages only until they were read once. It reduces the load on buses with many packs.
+var EfficientPollPlan = []PollEntry{
+	{Page: PageState},
+	{Page: PageFactory, Once: true},
+	{Page: PageSerial, Once: true},
+	{Page: PageCycle, Every: 10},
+	{Page: PageUser, Every: 10},
+}
+
+type pollEntryState struct {
+	PollEntry
+	done bool
+}
+
+type pollPlan struct {
+	sync.Mutex
+
+	entries []pollEntryState
+	set     bool
+	index   int
+	round   int
+}
+
+// SetPollPlan changes which pages are read and how often. Each call to Access() reads one page.
+// When nothing in the plan is due, PageState is read, so a battery that left the bus is always
+// detected. When nil, DefaultPollPlan is used. Once is reset for all pages.
+func (d *DeviceBattery) SetPollPlan(plan []PollEntry) {
+	d.poll.Lock()
+	defer d.poll.Unlock()
+
+	d.poll.setLocked(plan)
+}
+
+func (p *pollPlan) setLocked(plan []PollEntry) {
+	if plan == nil {
+		plan = DefaultPollPlan
+	}
+
+	p.entries = p.entries[:0]
+	for _, m := range plan {
+		p.entries = append(p.entries, pollEntryState{PollEntry: m})
+	}
+	p.set = true
+	p.index = 0
+	p.round = 0
+}
+
+/* Returns the next page that is due, or false if the plan has nothing left to read */
+func (d *DeviceBattery) pollNext() (Page, bool) {
+	d.poll.Lock()
+	defer d.poll.Unlock()
+
+	p := &d.poll
+	if !p.set {
+		p.setLocked(nil)
+	}
+
+	for i := 0; i < len(p.entries); i++ {
+		entry := &p.entries[p.index]
+		round := p.round
+
+		p.index++
+		if p.index >= len(p.entries) {
+			p.index = 0
+			p.round++
+		}
+
+		if entry.Once && entry.done {
+			continue
+		}
+		if entry.Every > 1 && round%entry.Every != 0 {
+			continue
+		}
+		return entry.Page, true
+	}
+
+	return 0, false
+}
+
+/* Records the result of reading a page */
+func (d *DeviceBattery) pollDone(page Page, ok bool) {
+	if !ok {
+		return
+	}
+
+	d.poll.Lock()
+	defer d.poll.Unlock()
+
+	for i := range d.poll.entries {
+		if d.poll.entries[i].Page == page {
+			d.poll.entries[i].done = true
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package battery
+
+import "time"
+
+// BatterySnapshot is a copy of BatteryData without the lock. The JSON encoding uses snake_case
+// field names, the same as BatteryData.MarshalJSON().
+type BatterySnapshot struct {
+	Connected bool      `json:"connected"`
+	LastData  time.Time `json:"last_data"`
+	Restored  bool      `json:"restored"`
+
+	BusAddress       uint8  `json:"bus_address"`
+	Serial           string `json:"serial"`
+	ManufacturerName string `json:"manufacturer_name"`
+
+	BatteryType                 BatteryType `json:"battery_type"`
+	CellDischargeCutOffV        float32     `json:"cell_discharge_cut_off_v"`
+	CellDischargeNormalV        float32     `json:"cell_discharge_normal_v"`
+	CellChargeMaxV              float32     `json:"cell_charge_max_v"`
+	CellStorageDefaultV         float32     `json:"cell_storage_default_v"`
+	CellCapacityAh              float32     `json:"cell_capacity_ah"`
+	BatteryChargeMaxCurrentA    float32     `json:"battery_charge_max_current_a"`
+	BatteryDischargeMaxCurrentA float32     `json:"battery_discharge_max_current_a"`
+	TempUseLowC                 int         `json:"temp_use_low_c"`
+	TempUseHighC                int         `json:"temp_use_high_c"`
+	TempStorageLowC             int         `json:"temp_storage_low_c"`
+	TempStorageHighC            int         `json:"temp_storage_high_c"`
+	BatteryHasAutoDischarge     bool        `json:"battery_has_auto_discharge"`
+	BatteryNumberOfCells        int         `json:"battery_number_of_cells"`
+
+	BatteryPreferredChargeCurrentA float32 `json:"battery_preferred_charge_current_a"`
+	CellPreferredStorageVoltageV   float32 `json:"cell_preferred_storage_voltage_v"`
+	CellPreferredMaxVoltageV       float32 `json:"cell_preferred_max_voltage_v"`
+	BatterySelfDischargeEnabled    bool    `json:"battery_self_discharge_enabled"`
+	BatterySelfDischargeHours      int     `json:"battery_self_discharge_hours"`
+
+	BatteryChargeCycles         int `json:"battery_charge_cycles"`
+	BatteryErrorOverCharged     int `json:"battery_error_over_charged"`
+	BatteryErrorOverDischarged  int `json:"battery_error_over_discharged"`
+	BatteryErrorOverTemperature int `json:"battery_error_over_temperature"`
+
+	TempCurrentC int       `json:"temp_current_c"`
+	CellVoltageV []float32 `json:"cell_voltage_v"`
+
+	PackVoltageV  float32 `json:"pack_voltage_v"`
+	CellMinV      float32 `json:"cell_min_v"`
+	CellMaxV      float32 `json:"cell_max_v"`
+	CellSpreadV   float32 `json:"cell_spread_v"`
+	InStorageBand bool    `json:"in_storage_band"`
+
+	SoCPercent float32 `json:"soc_percent"`
+	SoCResting bool    `json:"soc_resting"`
+
+	FirmwareVersion int `json:"firmware_version"`
+	HardwareVersion int `json:"hardware_version"`
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// Snapshot returns a copy of the data of the battery. It takes the lock of Data, so the result can
+// be used freely, for example for JSON marshalling, without further locking.
+func (d *DeviceBattery) Snapshot() BatterySnapshot {
+	d.Data.RLock()
+	defer d.Data.RUnlock()
+
+	return d.Data.snapshotLocked()
+}
+
+func (b *BatteryData) snapshotLocked() BatterySnapshot {
+	return BatterySnapshot{
+		Connected: b.Connected,
+		LastData:  b.LastData,
+		Restored:  b.Restored,
+
+		BusAddress:       b.BusAddress,
+		Serial:           b.Serial,
+		ManufacturerName: b.ManufacturerName,
+
+		BatteryType:                 b.BatteryType,
+		CellDischargeCutOffV:        b.CellDischargeCutOffV,
+		CellDischargeNormalV:        b.CellDischargeNormalV,
+		CellChargeMaxV:              b.CellChargeMaxV,
+		CellStorageDefaultV:         b.CellStorageDefaultV,
+		CellCapacityAh:              b.CellCapacityAh,
+		BatteryChargeMaxCurrentA:    b.BatteryChargeMaxCurrentA,
+		BatteryDischargeMaxCurrentA: b.BatteryDischargeMaxCurrentA,
+		TempUseLowC:                 b.TempUseLowC,
+		TempUseHighC:                b.TempUseHighC,
+		TempStorageLowC:             b.TempStorageLowC,
+		TempStorageHighC:            b.TempStorageHighC,
+		BatteryHasAutoDischarge:     b.BatteryHasAutoDischarge,
+		BatteryNumberOfCells:        b.BatteryNumberOfCells,
+
+		BatteryPreferredChargeCurrentA: b.BatteryPreferredChargeCurrentA,
+		CellPreferredStorageVoltageV:   b.CellPreferredStorageVoltageV,
+		CellPreferredMaxVoltageV:       b.CellPreferredMaxVoltageV,
+		BatterySelfDischargeEnabled:    b.BatterySelfDischargeEnabled,
+		BatterySelfDischargeHours:      b.BatterySelfDischargeHours,
+
+		BatteryChargeCycles:         b.BatteryChargeCycles,
+		BatteryErrorOverCharged:     b.BatteryErrorOverCharged,
+		BatteryErrorOverDischarged:  b.BatteryErrorOverDischarged,
+		BatteryErrorOverTemperature: b.BatteryErrorOverTemperature,
+
+		TempCurrentC: b.TempCurrentC,
+		CellVoltageV: append([]float32(nil), b.CellVoltageV...),
+
+		PackVoltageV:  b.PackVoltageV,
+		CellMinV:      b.CellMinV,
+		CellMaxV:      b.CellMaxV,
+		CellSpreadV:   b.CellSpreadV,
+		InStorageBand: b.InStorageBand,
+
+		SoCPercent: b.SoCPercent,
+		SoCResting: b.SoCResting,
+
+		FirmwareVersion: b.FirmwareVersion,
+		HardwareVersion: b.HardwareVersion,
+		ProtocolVersion: b.ProtocolVersion,
+	}
+}
@@ -0,0 +1,49 @@
+package battery
+
+import "time"
+
+/* Open circuit cell voltage at 0%, 10%, ..., 100% state of charge */
+var socTables = map[BatteryType][11]float32{
+	BatteryTypeLiPo:  {3.27, 3.69, 3.73, 3.77, 3.80, 3.84, 3.87, 3.95, 4.02, 4.11, 4.20},
+	BatteryTypeLiHv:  {3.30, 3.70, 3.75, 3.79, 3.83, 3.87, 3.92, 4.00, 4.08, 4.20, 4.35},
+	BatteryTypeLiIon: {3.00, 3.45, 3.55, 3.62, 3.68, 3.74, 3.80, 3.87, 3.95, 4.05, 4.20},
+	BatteryTypeLiFe:  {2.50, 3.00, 3.20, 3.25, 3.27, 3.28, 3.30, 3.32, 3.33, 3.35, 3.40},
+	BatteryTypeNiMH:  {1.00, 1.15, 1.20, 1.22, 1.24, 1.25, 1.26, 1.28, 1.30, 1.33, 1.40},
+	BatteryTypePb:    {1.93, 1.95, 1.97, 1.99, 2.01, 2.03, 2.05, 2.07, 2.09, 2.11, 2.13},
+}
+
+const (
+	/* The pack is considered at rest when its voltage stayed within this band for socRestTime */
+	socRestToleranceV = 0.01
+	socRestTime       = time.Minute
+)
+
+/* Interpolates the state of charge from the average cell voltage, returns -1 for unknown chemistries */
+func socEstimate(batteryType BatteryType, cellV float32) float32 {
+	table, ok := socTables[batteryType]
+	if !ok {
+		return -1
+	}
+
+	if cellV <= table[0] {
+		return 0
+	}
+	for i := 1; i < len(table); i++ {
+		if cellV <= table[i] {
+			fraction := (cellV - table[i-1]) / (table[i] - table[i-1])
+			return (float32(i-1) + fraction) * 10
+		}
+	}
+	return 100
+}
+
+/* Updates the rest detection after every state read, also when the state did not change. Must be
+ * called with the lock held. */
+func (b *BatteryData) updateResting(now time.Time) {
+	delta := b.PackVoltageV - b.socRestV
+	if b.socRestSince.IsZero() || delta > socRestToleranceV || delta < -socRestToleranceV {
+		b.socRestV = b.PackVoltageV
+		b.socRestSince = now
+	}
+	b.SoCResting = now.Sub(b.socRestSince) >= socRestTime
+}
@@ -0,0 +1,76 @@
+package battery
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSoCEstimate(t *testing.T) {
+	for batteryType, table := range socTables {
+		for _, tc := range []struct {
+			cellV float32
+			soc   float32
+		}{
+			{table[0] - 0.1, 0},
+			{table[0], 0},
+			{table[5], 50},
+			{(table[4] + table[5]) / 2, 45},
+			{table[10], 100},
+			{table[10] + 0.1, 100},
+		} {
+			if soc := socEstimate(batteryType, tc.cellV); math.Abs(float64(soc-tc.soc)) > 0.01 {
+				t.Errorf("%v at %.3fV: estimated %.2f%%, expected %.2f%%", batteryType, tc.cellV, soc, tc.soc)
+			}
+		}
+
+		/* The tables must be increasing, or the interpolation is wrong */
+		for i := 1; i < len(table); i++ {
+			if table[i] <= table[i-1] {
+				t.Errorf("%v: table is not increasing at %d%%", batteryType, i*10)
+			}
+		}
+	}
+
+	if soc := socEstimate(BatteryType(0xEE), 3.7); soc != -1 {
+		t.Errorf("Unknown chemistry estimated at %.2f%%, expected -1", soc)
+	}
+}
+
+func TestSoCResting(t *testing.T) {
+	var b BatteryData
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	step := func(d time.Duration, packV float32) bool {
+		now = now.Add(d)
+		b.PackVoltageV = packV
+		b.updateResting(now)
+		return b.SoCResting
+	}
+
+	if step(0, 16.0) {
+		t.Fatal("Resting after the first read")
+	}
+	if step(socRestTime/2, 16.0+socRestToleranceV/2) {
+		t.Fatal("Resting before socRestTime passed")
+	}
+	if !step(socRestTime/2, 16.0-socRestToleranceV/2) {
+		t.Fatal("Not resting after socRestTime within the tolerance")
+	}
+
+	/* A change larger than the tolerance restarts the rest period */
+	if step(time.Second, 16.0+2*socRestToleranceV) {
+		t.Fatal("Still resting after the voltage changed")
+	}
+	if step(socRestTime-2*time.Second, 16.0+2*socRestToleranceV) {
+		t.Fatal("Resting before socRestTime passed since the change")
+	}
+	if !step(2*time.Second, 16.0+2*socRestToleranceV) {
+		t.Fatal("Not resting after socRestTime passed since the change")
+	}
+
+	/* Slow drift is measured against the voltage at the start of the rest period */
+	step(socRestTime/2, 16.0+2.6*socRestToleranceV)
+	if step(socRestTime/2, 16.0+3.2*socRestToleranceV) {
+		t.Fatal("Still resting after the voltage drifted out of the tolerance")
+	}
+}
@@ -0,0 +1,122 @@
+package battery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/controller"
+)
+
+// StoredData is the information of a battery that is kept in a Store. The pages are stored
+// raw, as received from the battery.
+type StoredData struct {
+	LastData time.Time
+	Pages    map[Page][]byte
+}
+
+// Store persists the data of batteries by serial, so it is available immediately when a battery
+// is seen again. Errors are ignored by the battery, implementations should report them if needed.
+type Store interface {
+	// Load returns the stored data of a battery, or nil if it is not known.
+	Load(serial string) (*StoredData, error)
+	// Save replaces the stored data of a battery.
+	Save(serial string, data *StoredData) error
+}
+
+/* Minimum time between two saves while the battery is connected */
+const storeInterval = time.Minute
+
+// MemoryStore is a Store that keeps the data in memory, for the lifetime of the process.
+type MemoryStore struct {
+	sync.Mutex
+	data map[string]*StoredData
+}
+
+// Load implements Store.
+func (m *MemoryStore) Load(serial string) (*StoredData, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.data[serial], nil
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(serial string, data *StoredData) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.data == nil {
+		m.data = make(map[string]*StoredData)
+	}
+	m.data[serial] = data
+	return nil
+}
+
+// NewWithStore creates a battery like New, and restores the data that was saved in store the last
+// time a battery with the same serial was seen. Restored is set in the data until the state was
+// read from the battery. The data is saved at most once per minute while the battery is
+// connected, and when it disconnects.
+func NewWithStore(device *controller.BusDevice, updateChan chan<- (*DeviceBattery), store Store) controller.FunctionalDevice {
+	d := New(device, updateChan).(*DeviceBattery)
+	d.store = store
+
+	stored, err := store.Load(d.Data.Serial)
+	if err != nil || stored == nil {
+		return d
+	}
+
+	for page, data := range stored.Pages {
+		if ptr, reply, delta := d.pageStorage(page); ptr != nil {
+			d.storeData(data, reply, ptr, delta)
+		}
+	}
+
+	d.Data.Lock()
+	d.Data.LastData = stored.LastData
+	d.Data.Restored = true
+	d.Data.Unlock()
+
+	return d
+}
+
+/* Returns where a page is kept, its reply opcode and the function that decodes it */
+func (d *DeviceBattery) pageStorage(page Page) (*[]byte, byte, func() (bool, error)) {
+	switch page {
+	case PageState:
+		return &d.currentState, OpcodeReadState + 1, d.deltaState
+	case PageCycle:
+		return &d.cycleInfo, OpcodeReadCycle + 1, d.deltaCycle
+	case PageUser:
+		return &d.userSettings, OpcodeReadUser + 1, d.deltaUser
+	case PageSerial:
+		return &d.serial, OpcodeReadSerial + 1, d.deltaSerial
+	case PageVersion:
+		return &d.versionInfo, OpcodeReadVersion + 1, d.deltaVersion
+	case PageFactory:
+		return &d.factoryInfo, OpcodeReadFactory + 1, d.deltaFactoryData
+	}
+	return nil, 0, nil
+}
+
+/* Saves the data in the store, unless it was saved recently. force skips the interval check. */
+func (d *DeviceBattery) storeSave(now time.Time, force bool) {
+	if d.store == nil || (!force && now.Sub(d.storeLast) < storeInterval) {
+		return
+	}
+	d.storeLast = now
+
+	stored := &StoredData{
+		Pages: make(map[Page][]byte),
+	}
+	for page := PageState; page <= PageFactory; page++ {
+		if ptr, _, _ := d.pageStorage(page); len(*ptr) > 0 {
+			stored.Pages[page] = append([]byte(nil), *ptr...)
+		}
+	}
+
+	d.Data.RLock()
+	stored.LastData = d.Data.LastData
+	d.Data.RUnlock()
+
+	d.store.Save(d.Data.Serial, stored)
+}
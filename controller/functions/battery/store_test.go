@@ -0,0 +1,111 @@
+package battery_test
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/controller"
+	"github.com/BertoldVdb/go-battgo/controller/functions/battery"
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestMemoryStoreRestore(t *testing.T) {
+	serial := []byte("2222222222")
+	p, port, err := phy.NewVirtualPair()
+	if err != nil {
+		t.Skipf("Virtual line not available: %v", err)
+	}
+	peer := &phy.PHY{Port: port}
+	go peer.Run()
+	defer peer.Close()
+
+	store := &battery.MemoryStore{}
+
+	var batteriesMutex sync.Mutex
+	var batteries []*battery.DeviceBattery
+	latest := func(n int) *battery.DeviceBattery {
+		batteriesMutex.Lock()
+		defer batteriesMutex.Unlock()
+
+		if len(batteries) < n {
+			return nil
+		}
+		return batteries[n-1]
+	}
+
+	c := controller.NewMonitor(p, func(device *controller.BusDevice) controller.FunctionalDevice {
+		d := battery.NewWithStore(device, nil, store).(*battery.DeviceBattery)
+
+		batteriesMutex.Lock()
+		batteries = append(batteries, d)
+		batteriesMutex.Unlock()
+		return d
+	}, nil)
+	defer c.Close()
+	go c.Run()
+
+	serialPage := append(append([]byte{battery.OpcodeReadSerial + 1}, serial...), 'T', 0)
+
+	/* The battery is seen for the first time, so nothing is restored */
+	if !waitFor(t, 5*time.Second, func() bool {
+		peer.TXSendPacket(7, 1, serialPage)
+		return latest(1) != nil
+	}) {
+		t.Fatal("Battery was not learned")
+	}
+	if latest(1).Snapshot().Restored {
+		t.Fatal("Data of a new battery is marked as restored")
+	}
+
+	peer.TXSendPacket(7, 1, statePage(25, 3700, 3710))
+	if !waitFor(t, 5*time.Second, func() bool { return len(latest(1).Snapshot().CellVoltageV) == 2 }) {
+		t.Fatal("State was not observed")
+	}
+	lastData := latest(1).Snapshot().LastData
+
+	/* Moving to another address disconnects the battery, which saves it, and creates it again */
+	if !waitFor(t, 5*time.Second, func() bool {
+		peer.TXSendPacket(8, 1, serialPage)
+		return latest(2) != nil
+	}) {
+		t.Fatal("Battery was not created again")
+	}
+	if latest(1).Snapshot().Connected {
+		t.Fatal("Old battery is still connected")
+	}
+
+	restored := latest(2).Snapshot()
+	if !restored.Restored {
+		t.Fatal("Data was not restored")
+	}
+	if restored.Serial != hex.EncodeToString(serial) || !restored.LastData.Equal(lastData) {
+		t.Fatalf("Restored serial %s with data from %v, expected %s from %v", restored.Serial, restored.LastData, hex.EncodeToString(serial), lastData)
+	}
+	if len(restored.CellVoltageV) != 2 || restored.CellVoltageV[0] != 3.7 || restored.CellVoltageV[1] != 3.71 {
+		t.Fatalf("Restored cell voltages %v", restored.CellVoltageV)
+	}
+
+	/* Reading the state replaces the restored data */
+	peer.TXSendPacket(8, 1, statePage(25, 3800, 3810))
+	if !waitFor(t, 5*time.Second, func() bool { return !latest(2).Snapshot().Restored }) {
+		t.Fatal("Data is still marked as restored after the state was read")
+	}
+	if current := latest(2).Snapshot(); current.CellVoltageV[0] != 3.8 || !current.LastData.After(lastData) {
+		t.Fatalf("Current data %v from %v", current.CellVoltageV, current.LastData)
+	}
+}
@@ -0,0 +1,157 @@
+package battery
+
+import "sync"
+
+// Field is a set of groups of BatteryData fields, used to subscribe to changes.
+type Field int
+
+const (
+	// FieldConnection covers Connected.
+	FieldConnection Field = 1 << iota
+	// FieldCellVoltages covers CellVoltageV and the values derived from it.
+	FieldCellVoltages
+	// FieldTemperature covers TempCurrentC.
+	FieldTemperature
+	// FieldCycles covers the charge cycle and error counters.
+	FieldCycles
+	// FieldUserSettings covers the fields that can be changed with SetConfiguration().
+	FieldUserSettings
+	// FieldFactory covers the battery type, limits and number of cells.
+	FieldFactory
+	// FieldManufacturer covers ManufacturerName.
+	FieldManufacturer
+	// FieldVersion covers the firmware, hardware and protocol version.
+	FieldVersion
+
+	// FieldAll covers all groups.
+	FieldAll Field = FieldConnection | FieldCellVoltages | FieldTemperature | FieldCycles | FieldUserSettings | FieldFactory | FieldManufacturer | FieldVersion
+)
+
+// ChangeEvent is passed to subscribers when fields of the battery data changed. Use
+// Battery.Snapshot() to get the new values.
+type ChangeEvent struct {
+	Battery *DeviceBattery
+	Fields  Field
+}
+
+type subscription struct {
+	fields Field
+	fn     func(event ChangeEvent)
+}
+
+type subscriptions struct {
+	sync.Mutex
+	list []*subscription
+}
+
+// Subscribe calls fn whenever one of the given fields changed. Callbacks are called from the
+// goroutine that runs the controller and should not block. The returned function removes the
+// subscription.
+func (d *DeviceBattery) Subscribe(fields Field, fn func(event ChangeEvent)) func() {
+	s := &subscription{fields: fields, fn: fn}
+
+	d.subscriptions.Lock()
+	d.subscriptions.list = append(d.subscriptions.list, s)
+	d.subscriptions.Unlock()
+
+	return func() {
+		d.subscriptions.Lock()
+		defer d.subscriptions.Unlock()
+
+		for i, m := range d.subscriptions.list {
+			if m == s {
+				d.subscriptions.list = append(d.subscriptions.list[:i], d.subscriptions.list[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (d *DeviceBattery) subscribed() bool {
+	d.subscriptions.Lock()
+	defer d.subscriptions.Unlock()
+
+	return len(d.subscriptions.list) > 0
+}
+
+func (d *DeviceBattery) notify(fields Field) {
+	if fields == 0 {
+		return
+	}
+
+	d.subscriptions.Lock()
+	list := append([]*subscription(nil), d.subscriptions.list...)
+	d.subscriptions.Unlock()
+
+	for _, m := range list {
+		if changed := m.fields & fields; changed != 0 {
+			m.fn(ChangeEvent{Battery: d, Fields: changed})
+		}
+	}
+}
+
+func float32Equal(a []float32, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/* Returns the groups of fields that differ between a and b */
+func fieldsChanged(a *BatterySnapshot, b *BatterySnapshot) Field {
+	var f Field
+
+	if a.Connected != b.Connected {
+		f |= FieldConnection
+	}
+	if !float32Equal(a.CellVoltageV, b.CellVoltageV) || a.InStorageBand != b.InStorageBand {
+		f |= FieldCellVoltages
+	}
+	if a.TempCurrentC != b.TempCurrentC {
+		f |= FieldTemperature
+	}
+	if a.BatteryChargeCycles != b.BatteryChargeCycles ||
+		a.BatteryErrorOverCharged != b.BatteryErrorOverCharged ||
+		a.BatteryErrorOverDischarged != b.BatteryErrorOverDischarged ||
+		a.BatteryErrorOverTemperature != b.BatteryErrorOverTemperature {
+		f |= FieldCycles
+	}
+	if a.BatteryPreferredChargeCurrentA != b.BatteryPreferredChargeCurrentA ||
+		a.CellPreferredStorageVoltageV != b.CellPreferredStorageVoltageV ||
+		a.CellPreferredMaxVoltageV != b.CellPreferredMaxVoltageV ||
+		a.BatterySelfDischargeEnabled != b.BatterySelfDischargeEnabled ||
+		a.BatterySelfDischargeHours != b.BatterySelfDischargeHours {
+		f |= FieldUserSettings
+	}
+	if a.BatteryType != b.BatteryType ||
+		a.CellDischargeCutOffV != b.CellDischargeCutOffV ||
+		a.CellDischargeNormalV != b.CellDischargeNormalV ||
+		a.CellChargeMaxV != b.CellChargeMaxV ||
+		a.CellStorageDefaultV != b.CellStorageDefaultV ||
+		a.CellCapacityAh != b.CellCapacityAh ||
+		a.BatteryChargeMaxCurrentA != b.BatteryChargeMaxCurrentA ||
+		a.BatteryDischargeMaxCurrentA != b.BatteryDischargeMaxCurrentA ||
+		a.TempUseLowC != b.TempUseLowC ||
+		a.TempUseHighC != b.TempUseHighC ||
+		a.TempStorageLowC != b.TempStorageLowC ||
+		a.TempStorageHighC != b.TempStorageHighC ||
+		a.BatteryHasAutoDischarge != b.BatteryHasAutoDischarge ||
+		a.BatteryNumberOfCells != b.BatteryNumberOfCells {
+		f |= FieldFactory
+	}
+	if a.ManufacturerName != b.ManufacturerName {
+		f |= FieldManufacturer
+	}
+	if a.FirmwareVersion != b.FirmwareVersion ||
+		a.HardwareVersion != b.HardwareVersion ||
+		a.ProtocolVersion != b.ProtocolVersion {
+		f |= FieldVersion
+	}
+
+	return f
+}
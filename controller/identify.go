@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+/* Command that returns the serial followed by the name of the device */
+const cmdIdentify = 0x84
+
+/* Reads the identification page of a new device and stores its class */
+func (c *Controller) deviceIdentify(dev *BusDevice) {
+	var rxBuf [256]byte
+
+	response, err := dev.CommandExecOptions(&CommandOptions{
+		Priority:    PriorityBackground,
+		ExpectReply: true,
+		Retries:     1,
+	}, []byte{cmdIdentify}, rxBuf[:])
+	if err != nil || len(response) < 11 || !bytes.Equal(response[1:11], dev.serial) {
+		c.log().Debug("Identification failed", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "error", err)
+		return
+	}
+
+	name := response[11:]
+	if index := bytes.IndexByte(name, 0); index >= 0 {
+		name = name[:index]
+	}
+
+	dev.Lock()
+	dev.identification = append([]byte(nil), response...)
+	dev.class = string(name)
+	dev.Unlock()
+
+	c.log().Debug("Device identified", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "class", dev.class)
+}
+
+/* Returns the factory to use for a device */
+func (c *Controller) deviceFactory(dev *BusDevice) func(device *BusDevice) FunctionalDevice {
+	if factory := c.factories.lookup(dev); factory != nil {
+		return factory
+	}
+	if factory, ok := c.ClassFactories[dev.GetClass()]; ok && factory != nil {
+		return factory
+	}
+	return c.newDev
+}
+
+// GetClass returns the name the device reported in its identification page, for example the
+// manufacturer or model. It is only available when the controller has class factories.
+func (d *BusDevice) GetClass() string {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.class
+}
+
+// GetIdentification returns the raw identification page of the device, or nil if it was not read.
+func (d *BusDevice) GetIdentification() []byte {
+	d.Lock()
+	defer d.Unlock()
+
+	return d.identification
+}
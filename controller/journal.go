@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// JournalEntry describes a command in the journal of a device.
+type JournalEntry struct {
+	// Time is the time the command was started.
+	Time time.Time
+	// Duration is the time until the response arrived or the command failed.
+	Duration time.Duration
+
+	Payload  []byte
+	Response []byte
+	Err      error
+}
+
+// Opcode returns the first byte of the command, or 0 if the payload was empty.
+func (e JournalEntry) Opcode() byte {
+	if len(e.Payload) == 0 {
+		return 0
+	}
+	return e.Payload[0]
+}
+
+type deviceJournal struct {
+	sync.Mutex
+
+	entries []JournalEntry
+	next    int
+}
+
+func (j *deviceJournal) record(size int, start time.Time, payload []byte, response []byte, err error) {
+	if size <= 0 {
+		return
+	}
+
+	entry := JournalEntry{
+		Time:     start,
+		Duration: time.Since(start),
+		Payload:  append([]byte(nil), payload...),
+		Err:      err,
+	}
+	if response != nil {
+		entry.Response = append([]byte(nil), response...)
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if len(j.entries) < size {
+		j.entries = append(j.entries, entry)
+		return
+	}
+	j.entries[j.next] = entry
+	j.next = (j.next + 1) % len(j.entries)
+}
+
+// Journal returns the most recent commands sent to the device, oldest first. It is only recorded
+// when the JournalSize of the controller Config is not zero.
+func (d *BusDevice) Journal() []JournalEntry {
+	j := &d.journal
+	j.Lock()
+	defer j.Unlock()
+
+	result := make([]JournalEntry, 0, len(j.entries))
+	result = append(result, j.entries[j.next:]...)
+	return append(result, j.entries[:j.next]...)
+}
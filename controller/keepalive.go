@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"context"
+	"encoding/hex"
+)
+
+/* Called after a failed Access(), returns true if the device should be considered gone */
+func (c *Controller) deviceFailed(dev *BusDevice) bool {
+	dev.Lock()
+	dev.failures++
+	failures := dev.failures
+	dev.Unlock()
+
+	if failures < c.config.DisconnectFailures {
+		return false
+	}
+
+	if c.config.KeepalivePing && c.deviceKeepalive(dev) {
+		dev.Lock()
+		dev.failures = 0
+		dev.Unlock()
+		return false
+	}
+
+	return true
+}
+
+func (c *Controller) deviceSucceeded(dev *BusDevice) {
+	dev.Lock()
+	dev.failures = 0
+	dev.Unlock()
+}
+
+/* Sends the address assignment again, a device that is still present confirms it */
+func (c *Controller) deviceKeepalive(dev *BusDevice) bool {
+	cmdSetAddress := [12]byte{2}
+	cmdSetAddress[1] = dev.address
+	copy(cmdSetAddress[2:], dev.serial)
+
+	response, err := c.commandExecTimeout(context.Background(), 0, PriorityBackground, 0, []uint8{dev.address}, cmdSetAddress[:], nil)
+	ok := err == nil && len(response) == 11 && response[0] == 3
+	c.log().Debug("Keepalive", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "ok", ok)
+	return ok
+}
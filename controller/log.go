@@ -0,0 +1,14 @@
+package controller
+
+import (
+	"log/slog"
+
+	"github.com/BertoldVdb/go-battgo/internal/logging"
+)
+
+func (c *Controller) log() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return logging.Discard
+}
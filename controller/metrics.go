@@ -0,0 +1,22 @@
+package controller
+
+import "time"
+
+// Metrics is an interface that can be implemented by telemetry systems to observe the controller.
+// The methods are called synchronously, so they should return quickly.
+type Metrics interface {
+	// OnPollCycle is called after every iteration of the poll loop, with its duration and the
+	// number of devices that were accessed.
+	OnPollCycle(d time.Duration, accessed int)
+
+	// OnScan is called for every enumeration attempt. found is true when an unassigned device
+	// answered.
+	OnScan(found bool)
+
+	// OnEnumerated is called when a device confirmed its address.
+	OnEnumerated(serial []byte, address uint8)
+
+	// OnCommand is called for every command sent by the controller. err is nil when a response
+	// was received.
+	OnCommand(address uint8, latency time.Duration, err error)
+}
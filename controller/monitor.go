@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+var (
+	// ErrorMonitorMode is returned when a command is sent by a controller in monitor mode.
+	ErrorMonitorMode = errors.New("Controller is in monitor mode")
+)
+
+// ObservingDevice is an optional interface for FunctionalDevices that can be used with a
+// controller in monitor mode. Observe is called with every response the device sends to the
+// master, for example so it can update its data model.
+type ObservingDevice interface {
+	Observe(response []byte)
+}
+
+type monitorPacket struct {
+	addrSource uint8
+	addrDest   uint8
+	payload    []byte
+}
+
+// NewMonitor creates a controller that never transmits. Instead, it builds the list of devices
+// from the traffic between another master and the devices on the bus. Devices are learned from
+// address confirmations and from answers to serial page reads (0x84). A device that was
+// enumerated before the monitor started is only found when the master reads its serial page.
+// FunctionalDevices that
+// implement ObservingDevice receive the responses sent by their device. Access() is never
+// called and CommandExec returns ErrorMonitorMode. A device is considered gone when it has not
+// been seen for MonitorTimeout.
+func NewMonitor(phy *phy.PHY, newDev func(device *BusDevice) FunctionalDevice, config *Config) *Controller {
+	c := NewWithConfig(phy, 0, newDev, config)
+	c.monitor = true
+	c.monitorChan = make(chan (monitorPacket), 64)
+
+	c.phyAttach(phy)
+
+	return c
+}
+
+func (c *Controller) monitorHandlePacket(addrSource uint8, addrDest uint8, payload []byte) error {
+	c.monitorChan <- monitorPacket{
+		addrSource: addrSource,
+		addrDest:   addrDest,
+		payload:    append([]byte(nil), payload...),
+	}
+	return nil
+}
+
+func (c *Controller) runMonitor() error {
+	lastSeen := make(map[*BusDevice]time.Time)
+
+	tick := c.config.Clock.After(time.Second)
+
+	for {
+		select {
+		case err := <-c.phyDone:
+			if err := c.phyRecover(err); err != nil {
+				return err
+			}
+
+		case now := <-tick:
+			tick = c.config.Clock.After(time.Second)
+			for _, dev := range c.devices {
+				if now.Sub(lastSeen[dev]) > c.config.MonitorTimeout {
+					delete(lastSeen, dev)
+					if err := c.deviceRemove(dev); err != nil {
+						return err
+					}
+				}
+			}
+			c.deviceCountCheck()
+
+		case pkt := <-c.monitorChan:
+			/* Only responses to the master are interesting */
+			if pkt.addrDest != 1 || len(pkt.payload) == 0 {
+				continue
+			}
+
+			dev, err := c.monitorDevice(pkt)
+			if err != nil {
+				return err
+			}
+			if dev == nil {
+				continue
+			}
+			lastSeen[dev] = c.config.Clock.Now()
+
+			if obs, ok := dev.GetDevice().(ObservingDevice); ok {
+				obs.Observe(pkt.payload)
+			}
+		}
+	}
+}
+
+/* Returns the serial contained in an address confirmation or a serial page answer */
+func monitorSerial(pkt monitorPacket) ([]byte, bool) {
+	if pkt.addrSource == 0 {
+		return nil, false
+	}
+	if len(pkt.payload) == 11 && pkt.payload[0] == 3 {
+		return pkt.payload[1:], true
+	}
+	if len(pkt.payload) >= 11 && pkt.payload[0] == 0x85 {
+		return pkt.payload[1:11], true
+	}
+	return nil, false
+}
+
+/* Finds the device that sent a packet. Address confirmations and serial page answers of unknown
+ * serials create a new device. */
+func (c *Controller) monitorDevice(pkt monitorPacket) (*BusDevice, error) {
+	if serial, ok := monitorSerial(pkt); ok {
+		dev, ok := c.devices[string(serial)]
+		if ok && dev.address == pkt.addrSource {
+			return dev, nil
+		}
+		if ok {
+			/* The device got a new address, handle it as a reconnection */
+			if err := c.deviceRemove(dev); err != nil {
+				return nil, err
+			}
+		}
+
+		dev = &BusDevice{
+			controller: c,
+			key:        string(serial),
+			serial:     serial,
+			address:    pkt.addrSource,
+			device:     &dummyDevice{},
+		}
+
+		c.devicesMutex.Lock()
+		c.devices[string(serial)] = dev
+		c.devicesMutex.Unlock()
+
+		c.log().Info("Device observed", "serial", hex.EncodeToString(dev.serial), "address", dev.address)
+
+		c.deviceAttach(dev)
+
+		if c.OnDeviceAdded != nil {
+			c.OnDeviceAdded(dev)
+		}
+		return dev, nil
+	}
+
+	for _, dev := range c.devices {
+		if dev.address == pkt.addrSource {
+			return dev, nil
+		}
+	}
+	return nil, nil
+}
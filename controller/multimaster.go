@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+/* Packets we sent recently, so our own echo is not mistaken for another master */
+const foreignEchoWindow = 100 * time.Millisecond
+
+type sentPacket struct {
+	addrDest uint8
+	payload  []byte
+	t        time.Time
+}
+
+type foreignMaster struct {
+	sync.Mutex
+
+	sent     [8]sentPacket
+	sentNext int
+
+	last time.Time
+	busy bool
+}
+
+func (f *foreignMaster) transmitted(now time.Time, addrDest uint8, payload []byte) {
+	f.Lock()
+	defer f.Unlock()
+
+	s := &f.sent[f.sentNext]
+	s.addrDest = addrDest
+	s.payload = append(s.payload[:0], payload...)
+	s.t = now
+	f.sentNext = (f.sentNext + 1) % len(f.sent)
+}
+
+/* Returns if the packet was sent by another master and if the bus just became busy */
+func (f *foreignMaster) received(now time.Time, addrDest uint8, payload []byte) (bool, bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	for i := range f.sent {
+		s := &f.sent[i]
+		if s.addrDest == addrDest && now.Sub(s.t) < foreignEchoWindow && bytes.Equal(s.payload, payload) {
+			s.t = time.Time{}
+			return false, false
+		}
+	}
+
+	f.last = now
+	wasBusy := f.busy
+	f.busy = true
+	return true, !wasBusy
+}
+
+/* Returns if the bus is busy and if it just became free */
+func (f *foreignMaster) check(now time.Time, holdoff time.Duration) (bool, bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.busy && now.Sub(f.last) > holdoff {
+		f.busy = false
+		return false, true
+	}
+	return f.busy, false
+}
+
+// BusBusy returns true when another master was recently seen on the bus. While this is the case
+// the controller does not scan or poll devices.
+func (c *Controller) BusBusy() bool {
+	busy, _ := c.foreign.check(c.config.Clock.Now(), c.config.ForeignMasterHoldoff)
+	return busy
+}
+
+func (c *Controller) foreignReceived(addrDest uint8, payload []byte) {
+	foreign, changed := c.foreign.received(c.config.Clock.Now(), addrDest, payload)
+	if foreign && changed {
+		c.log().Warn("Foreign master detected, backing off")
+		if c.OnBusBusy != nil {
+			c.OnBusBusy(true)
+		}
+	}
+}
+
+/* Called by Run(), returns true if the controller should not use the bus */
+func (c *Controller) foreignBackoff() bool {
+	busy, changed := c.foreign.check(c.config.Clock.Now(), c.config.ForeignMasterHoldoff)
+	if changed {
+		c.log().Info("Foreign master gone, resuming")
+		if c.OnBusBusy != nil {
+			c.OnBusBusy(false)
+		}
+	}
+	return busy
+}
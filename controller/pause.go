@@ -0,0 +1,37 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// ErrorPaused is returned when a command is sent while the controller is paused.
+	ErrorPaused = errors.New("Controller is paused")
+)
+
+// Pause stops all polling, scanning and transmission, so another tool can temporarily use the
+// bus. Known devices are kept. Commands return ErrorPaused until Resume() is called. Pause returns
+// when all commands that were being executed have finished.
+func (c *Controller) Pause() {
+	atomic.StoreUint32(&c.paused, 1)
+	c.log().Info("Paused")
+
+	/* Taking all slots waits for the outstanding commands */
+	q := c.cmdQueue
+	q.acquireAll(context.Background(), PriorityInteractive)
+	q.releaseAll()
+}
+
+// Resume continues normal operation after Pause().
+func (c *Controller) Resume() {
+	if atomic.CompareAndSwapUint32(&c.paused, 1, 0) {
+		c.log().Info("Resumed")
+	}
+}
+
+// Paused returns true if the controller has been paused.
+func (c *Controller) Paused() bool {
+	return atomic.LoadUint32(&c.paused) != 0
+}
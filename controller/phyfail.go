@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+/* Installs the receive callbacks of the controller on a PHY */
+func (c *Controller) phyAttach(p *phy.PHY) {
+	if c.monitor {
+		p.RXHandlePresense = nil
+		p.RXHandlePacket = c.monitorHandlePacket
+		return
+	}
+
+	p.RXHandlePacket = c.rxHandlePacket
+	p.RXHandlePresense = func(b byte) error {
+		c.scanEvent(ScanEvent{Type: ScanEventPresence})
+		if c.devicesNumber < 0 {
+			c.detectStart()
+		}
+		if !c.scanBreakEcho() {
+			c.scanBackoffReset()
+		}
+		return nil
+	}
+}
+
+func (c *Controller) getPHY() *phy.PHY {
+	c.phyMutex.Lock()
+	defer c.phyMutex.Unlock()
+
+	return c.phy
+}
+
+func (c *Controller) phyStart() {
+	p := c.getPHY()
+	done := make(chan (error), 1)
+	c.phyDone = done
+
+	go func() {
+		done <- p.Run()
+	}()
+}
+
+/* Checks if the PHY stopped, which may be the cause of err. Returns nil if Run() can continue,
+ * either because the PHY is fine and err is nil or because a new PHY was started. */
+func (c *Controller) phyCheck(err error) error {
+	if err == nil {
+		select {
+		case perr := <-c.phyDone:
+			return c.phyRecover(perr)
+		default:
+			return nil
+		}
+	}
+
+	/* Give the PHY some time to notice the failure */
+	select {
+	case perr := <-c.phyDone:
+		return c.phyRecover(perr)
+	case <-time.After(100 * time.Millisecond):
+		return err
+	}
+}
+
+func (c *Controller) phyRecover(err error) error {
+	if atomic.LoadUint32(&c.closing) != 0 {
+		return err
+	}
+
+	c.log().Error("PHY stopped", "error", err)
+	if c.OnPHYFailure == nil {
+		return err
+	}
+
+	p := c.OnPHYFailure(err)
+	if p == nil {
+		return err
+	}
+
+	c.log().Info("PHY replaced, resuming")
+
+	c.phyAttach(p)
+	c.phyMutex.Lock()
+	c.phy = p
+	c.phyMutex.Unlock()
+
+	c.phyStart()
+	return nil
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Reenumerate sends a break to put all devices back in the unassigned state and enumerates them
+// again. Known devices keep their BusDevice, FunctionalDevice and address, and are not polled
+// until they confirmed their address. Devices that do not answer within the scan window are
+// disconnected. The protocol has no known command to release a single address, so this relies on
+// devices forgetting their address on a break. Use it to recover cleanly after error storms.
+func (c *Controller) Reenumerate() {
+	atomic.StoreUint32(&c.reenumerateRequest, 1)
+}
+
+/* Called by Run(), starts a requested re-enumeration */
+func (c *Controller) reenumerateStart() {
+	if !atomic.CompareAndSwapUint32(&c.reenumerateRequest, 1, 0) {
+		return
+	}
+
+	c.log().Info("Re-enumerating bus")
+
+	for _, dev := range c.devices {
+		dev.Lock()
+		dev.unconfirmed = true
+		dev.Unlock()
+	}
+	c.reenumerateDeadline = c.config.Clock.Now().Add(c.config.ScanWindow)
+
+	if p := c.getPHY(); p.TXSendBreak != nil {
+		c.scanBreak(p)
+	}
+	c.detectStart()
+}
+
+/* Returns true while devices still have to confirm their address. Devices that did not make it
+ * before the deadline are closed. */
+func (c *Controller) reenumeratePending() bool {
+	if c.reenumerateDeadline.IsZero() {
+		return false
+	}
+
+	expired := c.config.Clock.Now().After(c.reenumerateDeadline)
+	pending := false
+	for _, dev := range c.devices {
+		dev.Lock()
+		if dev.unconfirmed {
+			if expired {
+				dev.unconfirmed = false
+				dev.closeLocked()
+			} else {
+				pending = true
+			}
+		}
+		dev.Unlock()
+	}
+
+	if !pending {
+		c.reenumerateDeadline = time.Time{}
+	}
+	return pending
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ResetBus drops all known devices, releases their addresses and enumerates the bus from scratch,
+// starting with a break if the PHY supports it. Unlike Reenumerate(), every device gets a new
+// BusDevice and FunctionalDevice, and OnDeviceRemoved and OnDeviceAdded are called. Use it to
+// recover from a desynchronized bus without restarting the process. The reset is performed by
+// Run() before its next enumeration pass.
+func (c *Controller) ResetBus() {
+	atomic.StoreUint32(&c.resetRequest, 1)
+}
+
+/* Called by Run(), performs a requested bus reset. Returns an error if Run() should terminate. */
+func (c *Controller) resetStart() error {
+	if !atomic.CompareAndSwapUint32(&c.resetRequest, 1, 0) {
+		return nil
+	}
+
+	c.log().Info("Resetting bus")
+
+	for _, dev := range c.devices {
+		if err := c.deviceRemove(dev); err != nil {
+			return err
+		}
+	}
+	c.staleEvict(true)
+
+	c.duplicatesReported = make(map[string]bool)
+	c.reenumerateDeadline = time.Time{}
+	c.scanBackoff = 0
+	c.scanCount = 0
+
+	/* The bus is now empty, so the next scan starts with a break */
+	atomic.StoreUint32(&c.scanForce, 1)
+	c.detectStart()
+	return nil
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+	"sort"
+)
+
+/* Returns true if a response from addr is accepted by a command */
+func (d *cmdData) accepts(addr uint8) bool {
+	for _, a := range d.addrResponse {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+/* Only one command can wait for a response from a given address. The locks are taken in
+ * ascending order, so commands with overlapping address sets cannot deadlock. Returns the
+ * function that unlocks them again, or the error of ctx if it ended while waiting. */
+func (c *Controller) addrLock(ctx context.Context, addrs []uint8) (func(), error) {
+	sorted := append([]uint8(nil), addrs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var locked []uint8
+	unlock := func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			<-c.cmdAddrLock[locked[i]]
+		}
+	}
+
+	for _, a := range sorted {
+		if len(locked) > 0 && a == locked[len(locked)-1] {
+			continue
+		}
+		select {
+		case c.cmdAddrLock[a] <- struct{}{}:
+			locked = append(locked, a)
+		case <-ctx.Done():
+			unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	return unlock, nil
+}
@@ -1,14 +1,25 @@
 package controller
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"sync/atomic"
-	"time"
 )
 
 func (c *Controller) detectStart() {
 	c.scanTimeMutex.Lock()
 	defer c.scanTimeMutex.Unlock()
-	c.scanTime = time.Now().Add(20 * time.Second)
+	c.scanTime = c.config.Clock.Now().Add(c.config.ScanWindow)
+}
+
+// TriggerScan makes the controller perform an enumeration pass as soon as possible, regardless
+// of the configured number of devices or the scan backoff. Afterwards the controller keeps
+// scanning for the normal scan window.
+func (c *Controller) TriggerScan() {
+	atomic.StoreUint32(&c.scanForce, 1)
+	c.detectStart()
+	c.scanBackoffReset()
 }
 
 func (c *Controller) detectAndConfigure() error {
@@ -17,7 +28,11 @@ func (c *Controller) detectAndConfigure() error {
 		atomic.StoreUint32(&c.devicesMax, uint32(len(c.devices)))
 	}
 
-	if c.devicesNumber >= 0 {
+	c.reenumerateStart()
+
+	if atomic.CompareAndSwapUint32(&c.scanForce, 1, 0) || c.reenumeratePending() {
+		/* Forced scan, skip the checks below */
+	} else if c.devicesNumber >= 0 {
 		if c.devicesNumber > 0 && len(c.devices) >= c.devicesNumber {
 			return nil
 		}
@@ -26,9 +41,9 @@ func (c *Controller) detectAndConfigure() error {
 		scanTime := c.scanTime
 		c.scanTimeMutex.Unlock()
 
-		if len(c.devices) >= devicesMax && devicesMax > 0 && time.Now().After(scanTime) {
+		if len(c.devices) >= devicesMax && devicesMax > 0 && c.config.Clock.Now().After(scanTime) {
 			c.scanCount++
-			if c.scanCount >= 10 {
+			if c.scanCount >= c.config.ScanSkip {
 				c.scanCount = 0
 			} else {
 				return nil
@@ -36,28 +51,67 @@ func (c *Controller) detectAndConfigure() error {
 		}
 	}
 
-	if len(c.devices) == 0 && c.phy.TXSendBreak != nil {
-		c.phy.TXSendBreak(200 * time.Millisecond)
-		time.Sleep(30 * time.Millisecond)
+	if len(c.devices) == 0 {
+		c.scanBackoffWait()
+	}
+
+	if p := c.getPHY(); len(c.devices) == 0 && p.TXSendBreak != nil {
+		c.scanBreak(p)
 	}
 
 	cmdPingAll := [12]byte{2}
+	c.scanEvent(ScanEvent{Type: ScanEventStarted})
 
-	response, err := c.commandExecTimeout(0, 0, 0, cmdPingAll[:], nil)
-	if err != nil {
+	response, err := c.commandExecTimeout(context.Background(), 0, PriorityBackground, 0, []uint8{0}, cmdPingAll[:], nil)
+	found := err == nil && len(response) == 11 && response[0] == 3
+	c.scanBackoffUpdate(found)
+	if c.Metrics != nil {
+		c.Metrics.OnScan(found)
+	}
+	if errors.Is(err, ErrorTimeout) {
+		/* No unassigned device on the bus */
+		return nil
+	} else if err != nil {
 		return err
 	}
 
 	if len(response) == 11 && response[0] == 3 {
-		dev, ok := c.devices[string(response[1:])]
+		c.scanEvent(ScanEvent{Type: ScanEventDiscovered, Serial: response[1:]})
+
+		key := string(response[1:])
+		dev, ok := c.devices[key]
+		if ok && c.duplicateCheck(dev) {
+			if !c.config.DuplicateSerials {
+				return nil
+			}
+			ok = false
+		}
+
+		revived := false
+		if !ok && dev == nil {
+			dev = c.staleRevive(key)
+			ok = dev != nil
+			revived = ok
+		}
+
 		if !ok {
-			address, ok := c.addressFindFree()
-			if !ok {
+			address, err := c.addressAssign(response[1:])
+			if err != nil {
+				c.log().Warn("Cannot assign address", "serial", hex.EncodeToString(response[1:]), "error", err)
+				c.errors.add(0, err)
+				c.scanEvent(ScanEvent{Type: ScanEventFailed, Serial: response[1:], Err: err})
 				return nil
 			}
+			c.log().Info("Assigned address", "serial", hex.EncodeToString(response[1:]), "address", address)
+
+			if dev != nil {
+				/* Duplicate serial, make the key unique using the address */
+				key += string([]byte{0, address})
+			}
 
 			dev = &BusDevice{
 				controller: c,
+				key:        key,
 				serial:     response[1:],
 				address:    address,
 
@@ -65,28 +119,52 @@ func (c *Controller) detectAndConfigure() error {
 				deviceNew: true,
 			}
 
-			c.devices[string(response[1:])] = dev
+			c.devicesMutex.Lock()
+			c.devices[key] = dev
+			c.devicesMutex.Unlock()
 		}
 
 		cmdSetAddress := [12]byte{2}
 		cmdSetAddress[1] = dev.address
 		copy(cmdSetAddress[2:], response[1:])
 
-		response, err = c.commandExecTimeout(0, 0, dev.address, cmdSetAddress[:], nil)
-		if err != nil {
+		/* Some devices answer the assignment from the unassigned address */
+		response, err = c.commandExecTimeout(context.Background(), 0, PriorityBackground, 0, []uint8{dev.address, 0}, cmdSetAddress[:], nil)
+		if err != nil && !errors.Is(err, ErrorTimeout) {
 			return err
 		}
 
 		if len(response) != 11 || response[0] != 3 {
+			c.log().Debug("Device did not confirm address", "serial", hex.EncodeToString(dev.serial), "address", dev.address, "error", ErrorBadResponse)
+			c.errors.add(dev.address, ErrorBadResponse)
+			c.scanEvent(ScanEvent{Type: ScanEventFailed, Serial: dev.serial, Address: dev.address, Err: ErrorBadResponse})
 			dev.close()
 			return nil
 		}
 
+		dev.Lock()
+		dev.unconfirmed = false
+		dev.Unlock()
+
+		if c.Metrics != nil {
+			c.Metrics.OnEnumerated(dev.serial, dev.address)
+		}
+		c.scanEvent(ScanEvent{Type: ScanEventAssigned, Serial: dev.serial, Address: dev.address})
+
 		if dev.deviceNew {
 			dev.deviceNew = false
+			c.log().Info("Device enumerated", "serial", hex.EncodeToString(dev.serial), "address", dev.address)
 
-			if d := c.newDev(dev); d != nil {
-				dev.device = d
+			if err := c.deviceAttach(dev); err != nil {
+				return err
+			}
+
+			if c.OnDeviceAdded != nil {
+				c.OnDeviceAdded(dev)
+			}
+		} else if revived {
+			if err := c.deviceConnect(dev); err != nil {
+				return err
 			}
 		}
 	}
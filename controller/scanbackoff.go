@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+/* First delay after an unsuccessful scan of an empty bus */
+const scanBackoffStart = 100 * time.Millisecond
+
+/* Called before scanning an empty bus, waits for the backoff delay or a presence pulse */
+func (c *Controller) scanBackoffWait() {
+	if c.config.ScanBackoffMax <= 0 || c.scanBackoff == 0 {
+		return
+	}
+
+	select {
+	case <-c.config.Clock.After(c.scanBackoff):
+	case <-c.scanWake:
+		c.scanBackoff = 0
+	}
+}
+
+/* Called after a scan, doubles the delay when nothing answered on an empty bus */
+func (c *Controller) scanBackoffUpdate(found bool) {
+	if found || len(c.devices) > 0 {
+		c.scanBackoff = 0
+		return
+	}
+
+	if c.scanBackoff == 0 {
+		c.scanBackoff = scanBackoffStart
+	} else {
+		c.scanBackoff *= 2
+	}
+	if c.scanBackoff > c.config.ScanBackoffMax {
+		c.scanBackoff = c.config.ScanBackoffMax
+	}
+}
+
+/* Sends a break and remembers when, so its echo is not mistaken for a presence pulse. The break
+ * holds the whole command queue, as it would corrupt outstanding commands. */
+func (c *Controller) scanBreak(p *phy.PHY) {
+	q := c.cmdQueue
+	q.acquireAll(context.Background(), PriorityInteractive)
+	defer q.releaseAll()
+
+	/* The break is bus timing, so it uses the system clock */
+	atomic.StoreInt64(&c.scanBreakTime, time.Now().UnixNano())
+	p.SendBreak(c.config.BreakDuration)
+	time.Sleep(c.config.BreakSettle)
+}
+
+func (c *Controller) scanBreakEcho() bool {
+	sent := time.Unix(0, atomic.LoadInt64(&c.scanBreakTime))
+	return time.Since(sent) < c.config.BreakDuration+c.config.BreakSettle+scanBackoffStart
+}
+
+/* Ends the current backoff delay, safe to call from any goroutine */
+func (c *Controller) scanBackoffReset() {
+	select {
+	case c.scanWake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,34 @@
+package controller
+
+// ScanEventType is the kind of a ScanEvent.
+type ScanEventType int
+
+const (
+	// ScanEventStarted is reported when the controller asks unassigned devices to identify.
+	ScanEventStarted ScanEventType = iota
+	// ScanEventPresence is reported when a presence signal was received.
+	ScanEventPresence
+	// ScanEventDiscovered is reported when an unassigned device answered with its serial.
+	ScanEventDiscovered
+	// ScanEventAssigned is reported when a device confirmed its address.
+	ScanEventAssigned
+	// ScanEventFailed is reported when a device could not be given an address. Err is
+	// ErrorNoFreeAddress when all addresses are in use, ErrorAddressInUse when its static address
+	// is taken, or ErrorBadResponse when the device did not confirm its address.
+	ScanEventFailed
+)
+
+// ScanEvent describes progress of the enumeration. Serial and Address are only set for events
+// about a specific device.
+type ScanEvent struct {
+	Type    ScanEventType
+	Serial  []byte
+	Address uint8
+	Err     error
+}
+
+func (c *Controller) scanEvent(event ScanEvent) {
+	if c.OnScanEvent != nil {
+		c.OnScanEvent(event)
+	}
+}
@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"sort"
+	"time"
+)
+
+// SetPriority changes how often the device is polled compared to other devices. A device with
+// priority p is polled p+1 times for each poll of a device with priority 0. The default is 0.
+func (d *BusDevice) SetPriority(priority int) {
+	if priority < 0 {
+		priority = 0
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.priority = priority
+}
+
+/* Returns the devices that can be polled, ordered by address so the order does not depend on the map */
+func (c *Controller) scheduleDevices() []*BusDevice {
+	devs := make([]*BusDevice, 0, len(c.devices))
+	for _, dev := range c.devices {
+		devs = append(devs, dev)
+	}
+	sort.Slice(devs, func(i, j int) bool {
+		return devs[i].address < devs[j].address
+	})
+	return devs
+}
+
+/* Builds the slot table of one cycle. A device with priority p gets p+1 slots, spread out over the cycle. */
+func scheduleSlotTable(devs []*BusDevice) []*BusDevice {
+	maxWeight := 0
+	weights := make([]int, len(devs))
+	for i, dev := range devs {
+		dev.Lock()
+		weights[i] = dev.priority + 1
+		dev.Unlock()
+
+		if weights[i] > maxWeight {
+			maxWeight = weights[i]
+		}
+	}
+
+	var table []*BusDevice
+	for round := 0; round < maxWeight; round++ {
+		for i, dev := range devs {
+			if round < weights[i] {
+				table = append(table, dev)
+			}
+		}
+	}
+	return table
+}
+
+/* Runs one cycle of fixed time slots. Each slot lasts SlotTime, even if its device is not due or
+ * answers quickly, so the timing only depends on the set of devices. */
+func (c *Controller) scheduleSlots(devs []*BusDevice) (int, error) {
+	accessed := 0
+
+	slotEnd := c.config.Clock.Now()
+	for _, dev := range scheduleSlotTable(devs) {
+		slotEnd = slotEnd.Add(c.config.SlotTime)
+
+		if due, _ := c.schedulePick([]*BusDevice{dev}); due != nil {
+			accessed++
+			if err := c.deviceAccess(dev); err != nil {
+				return accessed, err
+			}
+		}
+
+		if wait := slotEnd.Sub(c.config.Clock.Now()); wait > 0 {
+			c.config.Clock.Sleep(wait)
+		} else {
+			/* Overrun, start the next slot from now instead of trying to catch up */
+			slotEnd = c.config.Clock.Now()
+		}
+	}
+
+	return accessed, nil
+}
+
+type accessResult struct {
+	dev      *BusDevice
+	active   bool
+	err      error
+	duration time.Duration
+}
+
+/* Polls as many devices as there are in devs, with up to CommandSlots calls to Access() in flight
+ * so the commands to different devices overlap. The results are handled one at a time by the
+ * calling goroutine. Returns the number of devices polled and when the next one is due. */
+func (c *Controller) schedulePipelined(devs []*BusDevice) (int, time.Time, error) {
+	results := make(chan (accessResult), c.CommandSlots)
+	inFlight := make(map[*BusDevice]bool)
+	accessed := 0
+
+	var next time.Time
+	var err error
+	for {
+		/* Stop starting new calls after an error, but collect the ones that are running */
+		for err == nil && accessed < len(devs) && len(inFlight) < c.CommandSlots {
+			idle := make([]*BusDevice, 0, len(devs))
+			for _, dev := range devs {
+				if !inFlight[dev] {
+					idle = append(idle, dev)
+				}
+			}
+
+			var dev *BusDevice
+			dev, next = c.schedulePick(idle)
+			if dev == nil {
+				break
+			}
+			inFlight[dev] = true
+			accessed++
+
+			go func() {
+				start := c.config.Clock.Now()
+				active, err := c.deviceAccessCall(dev)
+				results <- accessResult{dev: dev, active: active, err: err, duration: c.config.Clock.Now().Sub(start)}
+			}()
+		}
+
+		if len(inFlight) == 0 {
+			return accessed, next, err
+		}
+
+		r := <-results
+		delete(inFlight, r.dev)
+		if errResult := c.deviceAccessResult(r.dev, r.active, r.err, r.duration); errResult != nil && err == nil {
+			err = errResult
+		}
+	}
+}
+
+/* Stride scheduling: pick the due device that has received the least service relative to its
+ * priority. Returns nil if no device is due, together with the time the next one will be. */
+func (c *Controller) schedulePick(devs []*BusDevice) (*BusDevice, time.Time) {
+	var best *BusDevice
+	var bestPass float64
+	var next time.Time
+
+	now := c.config.Clock.Now()
+	for _, dev := range devs {
+		dev.Lock()
+		if !dev.pollPassSet {
+			dev.pollPass = c.schedulePass
+			dev.pollPassSet = true
+		}
+
+		interval := dev.pollNext
+		if interval == 0 {
+			interval = dev.pollInterval
+		}
+		if interval == 0 {
+			interval = c.PollInterval
+		}
+		due := dev.pollLast.Add(interval)
+		pass := dev.pollPass
+		skip := dev.closed || dev.unconfirmed
+		dev.Unlock()
+
+		if skip {
+			continue
+		}
+
+		if now.Before(due) {
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+			continue
+		}
+
+		if best == nil || pass < bestPass {
+			best = dev
+			bestPass = pass
+		}
+	}
+
+	if best != nil {
+		best.Lock()
+		best.pollLast = now
+		best.pollPass += 1 / float64(best.priority+1)
+		c.schedulePass = bestPass
+		best.Unlock()
+	}
+
+	return best, next
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrorStaticAddress is returned by Run() when StaticAddresses contains a reserved address or
+	// maps several serials to the same address.
+	ErrorStaticAddress = errors.New("Invalid static address")
+	// ErrorAddressInUse is reported when a device has a static address that another device on the
+	// bus already uses, for example because its serial is duplicated.
+	ErrorAddressInUse = errors.New("Static address already in use")
+)
+
+/* Checks the static addresses and reserves them so they are never handed out dynamically */
+func (c *Controller) addressReserveStatic() error {
+	seen := make(map[uint8]bool)
+	for _, addr := range c.StaticAddresses {
+		if addressReserved(addr) {
+			return fmt.Errorf("%w: %d is reserved", ErrorStaticAddress, addr)
+		}
+		if seen[addr] {
+			return fmt.Errorf("%w: %d is used by more than one serial", ErrorStaticAddress, addr)
+		}
+		seen[addr] = true
+	}
+
+	for addr := range seen {
+		c.addressSetUsed(addr, true)
+	}
+	return nil
+}
+
+func addressReserved(addr uint8) bool {
+	return addr == 0x00 || addr == 0x01 || addr == 0xaa || addr == 0xff
+}
+
+func (c *Controller) addressStatic(addr uint8) bool {
+	for _, a := range c.StaticAddresses {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+/* Returns true if a known or stale device has this address */
+func (c *Controller) addressInUse(addr uint8) bool {
+	c.devicesMutex.Lock()
+	defer c.devicesMutex.Unlock()
+
+	for _, dev := range c.devices {
+		if dev.address == addr {
+			return true
+		}
+	}
+	for _, s := range c.stale {
+		if s.device.address == addr {
+			return true
+		}
+	}
+	return false
+}
+
+/* Returns the address for a new device: its static address if it has one, otherwise a free one */
+func (c *Controller) addressAssign(serial []byte) (uint8, error) {
+	if addr, ok := c.StaticAddresses[string(serial)]; ok {
+		if c.addressInUse(addr) {
+			return 0, ErrorAddressInUse
+		}
+		return addr, nil
+	}
+
+	addr, ok := c.addressFindFree()
+	if !ok {
+		return 0, ErrorNoFreeAddress
+	}
+	return addr, nil
+}
+
+func (c *Controller) addressRelease(addr uint8) {
+	if !c.addressStatic(addr) {
+		c.addressSetUsed(addr, false)
+	}
+}
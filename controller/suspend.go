@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/BertoldVdb/go-misc/slotset"
+)
+
+var (
+	// ErrorSuspended is returned for commands that were sent or waiting while the host was suspended.
+	ErrorSuspended = errors.New("Host is suspended")
+)
+
+// Suspend tells the controller that the host is about to sleep. Commands that are waiting for a
+// response fail with ErrorSuspended, and nothing is sent until Wake() is called. Devices are not
+// disconnected.
+func (c *Controller) Suspend() {
+	atomic.StoreUint32(&c.suspended, 1)
+	c.log().Info("Suspended")
+
+	c.commandsInvalidate(ErrorSuspended)
+}
+
+// Wake tells the controller that the host woke up. The controller resets its poll and scan timers
+// and re-enumerates the bus, because devices may have lost their address or left while the host
+// was asleep. Calling Wake() without Suspend() is allowed, for example when the application
+// learns about the wake-up afterwards. See also Config.ClockJumpThreshold.
+func (c *Controller) Wake() {
+	if atomic.CompareAndSwapUint32(&c.suspended, 1, 0) {
+		c.log().Info("Woke up")
+	}
+	atomic.StoreUint32(&c.wakeRequest, 1)
+}
+
+func (c *Controller) isSuspended() bool {
+	return atomic.LoadUint32(&c.suspended) != 0
+}
+
+/* Fails all commands that are waiting for a response */
+func (c *Controller) commandsInvalidate(err error) {
+	c.cmdSlotSet.IterateActive(func(slot *slotset.Slot) (bool, error) {
+		slot.PostWithoutLock(err)
+		return true, nil
+	})
+}
+
+/* Returns true if the wall clock and the monotonic clock drifted apart since the last call, which
+ * happens when the host was suspended or the time was changed */
+func (c *Controller) clockJumped() bool {
+	now := time.Now()
+	last := c.clockLast
+	c.clockLast = now
+
+	if c.config.ClockJumpThreshold <= 0 || last.IsZero() {
+		return false
+	}
+
+	jump := now.Round(0).Sub(last.Round(0)) - now.Sub(last)
+	return jump > c.config.ClockJumpThreshold || -jump > c.config.ClockJumpThreshold
+}
+
+/* Called by Run(), handles a wake-up that was signalled or detected */
+func (c *Controller) wakeStart() {
+	jumped := c.clockJumped()
+	if !atomic.CompareAndSwapUint32(&c.wakeRequest, 1, 0) && !jumped {
+		return
+	}
+
+	if jumped {
+		c.log().Info("Clock jump detected, assuming the host was suspended")
+		c.commandsInvalidate(ErrorSuspended)
+	}
+
+	for _, dev := range c.devices {
+		dev.Lock()
+		dev.failures = 0
+		dev.pollLast = time.Time{}
+		dev.Unlock()
+	}
+	c.scanBackoff = 0
+	c.scanCount = 0
+
+	c.Reenumerate()
+}
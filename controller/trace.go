@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// CommandTrace describes a command executed by the controller, as seen by command hooks.
+type CommandTrace struct {
+	AddrDest     uint8
+	AddrResponse uint8
+	Priority     CommandPriority
+	Payload      []byte
+
+	// The fields below are only valid in hooks added with AddCommandHookAfter.
+	Response []byte
+	Latency  time.Duration
+	Err      error
+}
+
+// CommandHook is a function that observes commands. It must not modify the trace or keep
+// references to its slices after returning.
+type CommandHook func(trace *CommandTrace)
+
+type commandHooks struct {
+	sync.RWMutex
+	before []CommandHook
+	after  []CommandHook
+}
+
+func (h *commandHooks) run(hooks *[]CommandHook, trace *CommandTrace) {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, m := range *hooks {
+		m(trace)
+	}
+}
+
+func (h *commandHooks) empty() bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	return len(h.before) == 0 && len(h.after) == 0
+}
+
+// AddCommandHookBefore adds a hook that is called before every command is sent, including the
+// commands used for enumeration. Hooks are called in the order they were added.
+func (c *Controller) AddCommandHookBefore(hook CommandHook) {
+	c.hooks.Lock()
+	defer c.hooks.Unlock()
+
+	c.hooks.before = append(c.hooks.before, hook)
+}
+
+// AddCommandHookAfter adds a hook that is called after every command finished, with its response,
+// latency and error. Hooks are called in the order they were added.
+func (c *Controller) AddCommandHookAfter(hook CommandHook) {
+	c.hooks.Lock()
+	defer c.hooks.Unlock()
+
+	c.hooks.after = append(c.hooks.after, hook)
+}
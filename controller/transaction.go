@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"time"
+)
+
+type cmdHeldKey struct{}
+
+/* Returns true if the context belongs to a transaction that already holds the bus */
+func cmdHeld(ctx context.Context) bool {
+	held, _ := ctx.Value(cmdHeldKey{}).(bool)
+	return held
+}
+
+/* Takes all slots of the queue, so no other command can be sent until releaseAll is called */
+func (q *cmdQueue) acquireAll(ctx context.Context, priority CommandPriority) error {
+	q.allMutex.Lock()
+	defer q.allMutex.Unlock()
+
+	for i := 0; i < q.slots; i++ {
+		if err := q.acquire(ctx, priority); err != nil {
+			for ; i > 0; i-- {
+				q.release()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *cmdQueue) releaseAll() {
+	for i := 0; i < q.slots; i++ {
+		q.release()
+	}
+}
+
+// Transaction gives access to the bus during BusDevice.Transaction().
+type Transaction struct {
+	device *BusDevice
+	ctx    context.Context
+}
+
+// CommandExec sends a command as part of the transaction. See BusDevice.CommandExec().
+func (t *Transaction) CommandExec(payload []byte, response []byte) ([]byte, error) {
+	return t.device.CommandExec(t.ctx, payload, response)
+}
+
+// CommandExecTimeout sends a command with a timeout as part of the transaction. The timeout is
+// limited by the deadline of the transaction. When it is 0, the CommandTimeout of the controller
+// Config is used. Unlike BusDevice.CommandExecTimeout(), ErrorTimeout is always returned on timeout.
+func (t *Transaction) CommandExecTimeout(timeout time.Duration, payload []byte, response []byte) ([]byte, error) {
+	if timeout == 0 {
+		timeout = t.device.controller.config.CommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(t.ctx, timeout)
+	defer cancel()
+
+	return t.device.CommandExec(ctx, payload, response)
+}
+
+// Transaction runs fn while holding the bus, so the commands it sends using the Transaction are
+// executed back-to-back without commands to other devices in between. Use it for multi-step
+// operations, like writing a configuration and reading it back. The context limits the time to
+// wait for the bus and the duration of the whole transaction. fn should not use other methods of
+// the device or controller to send commands, as they would wait for the transaction to finish.
+func (d *BusDevice) Transaction(ctx context.Context, priority CommandPriority, fn func(tx *Transaction) error) error {
+	if d.isClosed() {
+		return ErrorClosed
+	}
+
+	q := d.controller.cmdQueue
+	if err := q.acquireAll(ctx, priority); err != nil {
+		return err
+	}
+	defer q.releaseAll()
+
+	return fn(&Transaction{
+		device: d,
+		ctx:    context.WithValue(ctx, cmdHeldKey{}, true),
+	})
+}
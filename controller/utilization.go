@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/* Inserts idle time after each command so the bus is busy for at most a fraction of the time */
+type utilization struct {
+	sync.Mutex
+	idleUntil time.Time
+}
+
+func (u *utilization) wait(ctx context.Context, clock Clock) error {
+	u.Lock()
+	d := u.idleUntil.Sub(clock.Now())
+	u.Unlock()
+
+	if d <= 0 {
+		return nil
+	}
+
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (u *utilization) busy(clock Clock, start time.Time, limit float64) {
+	if limit <= 0 || limit >= 1 {
+		return
+	}
+
+	now := clock.Now()
+	idle := time.Duration(float64(now.Sub(start)) * (1 - limit) / limit)
+
+	u.Lock()
+	defer u.Unlock()
+
+	if until := now.Add(idle); until.After(u.idleUntil) {
+		u.idleUntil = until
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/BertoldVdb/go-battgo
 
-go 1.16
+go 1.21
 
-require github.com/BertoldVdb/go-misc v0.1.5 // indirect
+require (
+	github.com/BertoldVdb/go-misc v0.1.5
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f
+)
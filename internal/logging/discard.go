@@ -0,0 +1,17 @@
+// Package logging contains logging helpers shared by the packages of this module.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Discard is a logger that drops all records. It is used when no logger was configured.
+var Discard = slog.New(discardHandler{})
@@ -4,31 +4,78 @@ import (
 	"encoding/json"
 	"flag"
 	"log"
+	"os"
 
 	"github.com/BertoldVdb/go-battgo/controller"
 	"github.com/BertoldVdb/go-battgo/controller/functions/battery"
 	"github.com/BertoldVdb/go-battgo/phy"
 )
 
+func discoverPort(options *phy.SerialOptions) string {
+	ports, err := phy.DiscoverSerial(options, 0)
+	if err != nil {
+		log.Fatalln("Could not discover serial ports", err)
+	}
+
+	for _, m := range ports {
+		if m.DeviceSeen {
+			return m.PortName
+		}
+	}
+	if len(ports) > 0 {
+		return ports[0].PortName
+	}
+
+	log.Fatalln("No serial port with a BattGO interface found")
+	return ""
+}
+
 func main() {
-	port := flag.String("port", "/dev/ttyUSB0", "Serial port to use")
+	port := flag.String("port", "/dev/ttyUSB0", "Serial port to use, or 'auto' to probe all ports")
+	baud := flag.Uint("baud", 9600, "Serial port baud rate")
 	dev := flag.Int("devices", -1, "Number of devices on bus")
+	reconnect := flag.Bool("reconnect", false, "Reopen the serial port when it fails")
+	capture := flag.String("capture", "", "Write all raw frames to this capture file")
 	flag.Parse()
 
-	phy, err := phy.NewSerialSimple(*port)
+	options := phy.SerialOptions{
+		PortName:  *port,
+		BaudRate:  uint32(*baud),
+		Reconnect: *reconnect,
+		OnReconnectEvent: func(event phy.ReconnectEvent, portName string, err error) {
+			log.Println("Serial port", portName, "reconnect event", event, err)
+		},
+	}
+
+	if *port == "auto" {
+		options.PortName = discoverPort(&options)
+	}
+
+	p, err := phy.NewSerial(&options)
 	if err != nil {
 		log.Fatalln("Could not create PHY", err)
 	}
-	defer phy.Close()
+	defer p.Close()
+
+	if *capture != "" {
+		f, err := os.Create(*capture)
+		if err != nil {
+			log.Fatalln("Could not create capture file", err)
+		}
+		defer f.Close()
+
+		p.Capture, err = phy.NewCaptureWriter(f)
+		if err != nil {
+			log.Fatalln("Could not write capture file", err)
+		}
+	}
 
 	updateChan := make(chan (*battery.DeviceBattery), 1)
 	go func() {
 		for {
 			dev := <-updateChan
 
-			dev.Data.Lock()
 			b, err := json.MarshalIndent(&dev.Data, "", "  ")
-			dev.Data.Unlock()
 
 			if err == nil {
 				log.Println(string(b))
@@ -36,7 +83,7 @@ func main() {
 		}
 	}()
 
-	b := controller.New(phy, *dev, func(newDevice *controller.BusDevice) controller.FunctionalDevice {
+	b := controller.New(p, *dev, func(newDevice *controller.BusDevice) controller.FunctionalDevice {
 		return battery.New(newDevice, updateChan)
 	})
 
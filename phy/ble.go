@@ -0,0 +1,50 @@
+package phy
+
+// BLECharacteristic is the part of a GATT characteristic used by the BLE transport. It matches
+// the API of common Go Bluetooth stacks, such as tinygo.org/x/bluetooth, so their characteristic
+// objects can be passed in directly.
+type BLECharacteristic interface {
+	WriteWithoutResponse(p []byte) (int, error)
+	EnableNotifications(callback func(buf []byte)) error
+}
+
+// BLEOptions contains the parameters used by NewBLE.
+type BLEOptions struct {
+	// RX is the characteristic that notifies data sent by the battery.
+	RX BLECharacteristic
+
+	// TX is the characteristic that data for the battery is written to. It may be the same
+	// characteristic as RX.
+	TX BLECharacteristic
+
+	// MTU is the largest payload written in one operation. Defaults to 20, the minimum
+	// supported by all BLE devices.
+	MTU int
+
+	// Disconnect is an optional function that is called when the PHY is closed.
+	Disconnect func() error
+}
+
+// NewBLE sets the PHY up for a device that carries the BattGO byte stream over a BLE GATT
+// connection. Breaks are not used on this transport, so TXSendBreak is left empty.
+func NewBLE(options *BLEOptions) (*PHY, error) {
+	mtu := options.MTU
+	if mtu == 0 {
+		mtu = 20
+	}
+
+	tx := options.TX
+	port := newMessagePort(mtu, func(p []byte) error {
+		_, err := tx.WriteWithoutResponse(p)
+		return err
+	}, options.Disconnect)
+
+	err := options.RX.EnableNotifications(port.deliver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PHY{
+		Port: port,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package phy
+
+// Bridge forwards the traffic between two PHYs. It can be used to extend a bus or to observe
+// and modify the communication between a charger and a battery.
+type Bridge struct {
+	a *PHY
+	b *PHY
+
+	// Handle is an optional callback that is called for every packet before it is forwarded.
+	// It may return a modified payload. When it returns false the packet is dropped.
+	Handle func(from *PHY, addrSource uint8, addrDest uint8, payload []byte) ([]byte, bool)
+}
+
+// NewBridge creates a bridge between two PHYs. It takes over the packet callbacks of both and
+// enables echo filtering, so forwarded packets are not sent back to where they came from.
+// Presence bytes are not forwarded, as their echo cannot be told apart from new ones.
+func NewBridge(a *PHY, b *PHY) *Bridge {
+	br := &Bridge{
+		a: a,
+		b: b,
+	}
+
+	br.connect(a, b)
+	br.connect(b, a)
+
+	return br
+}
+
+func (br *Bridge) connect(from *PHY, to *PHY) {
+	from.RXFilterEcho = true
+
+	from.RXHandlePacket = func(addrSource uint8, addrDest uint8, payload []byte) error {
+		if br.Handle != nil {
+			var forward bool
+			payload, forward = br.Handle(from, addrSource, addrDest, payload)
+			if !forward {
+				return nil
+			}
+		}
+
+		return to.TXSendPacket(addrSource, addrDest, payload)
+	}
+}
+
+// Run forwards packets until one of the PHYs fails. Both PHYs are closed when it returns.
+func (br *Bridge) Run() error {
+	errChan := make(chan (error), 2)
+
+	go func() {
+		errChan <- br.a.Run()
+	}()
+	go func() {
+		errChan <- br.b.Run()
+	}()
+
+	err := <-errChan
+	br.Close()
+	<-errChan
+
+	return err
+}
+
+// Close stops Run() and closes both PHYs.
+func (br *Bridge) Close() error {
+	errA := br.a.Close()
+	errB := br.b.Close()
+
+	if errA != nil {
+		return errA
+	}
+	return errB
+}
@@ -0,0 +1,84 @@
+package phy_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func newBridge(t *testing.T) (*phy.Bridge, *harness, *harness, chan (error)) {
+	localA, remoteA := newVirtualLine(t)
+	localB, remoteB := newVirtualLine(t)
+
+	br := phy.NewBridge(localA, localB)
+	br.Handle = func(from *phy.PHY, addrSource uint8, addrDest uint8, payload []byte) ([]byte, bool) {
+		if payload[0] == 0xFF {
+			return nil, false
+		}
+		if from == localB && payload[0] == 0x45 {
+			return append([]byte{0x45, 0x99}, payload[2:]...), true
+		}
+		return payload, true
+	}
+
+	result := make(chan (error), 1)
+	go func() {
+		result <- br.Run()
+	}()
+	t.Cleanup(func() {
+		br.Close()
+	})
+
+	return br, runHarness(t, &phy.PHY{Port: remoteA}, nil), runHarness(t, &phy.PHY{Port: remoteB}, nil), result
+}
+
+func expectReturn(t *testing.T, result chan (error)) error {
+	t.Helper()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	return nil
+}
+
+func TestBridgeForwards(t *testing.T) {
+	br, a, b, result := newBridge(t)
+
+	a.phy.TXSendPacket(1, 5, []byte{0x44})
+	b.expectPacket(t, 1, 5, []byte{0x44})
+
+	/* Modified by Handle on the way back */
+	b.phy.TXSendPacket(5, 1, []byte{0x45, 0x01, 0x02})
+	a.expectPacket(t, 5, 1, []byte{0x45, 0x99, 0x02})
+
+	/* Dropped by Handle */
+	a.phy.TXSendPacket(1, 5, []byte{0xFF})
+	b.phy.TXSendPacket(5, 1, []byte{0xFF})
+	a.expectNothing(t)
+	b.expectNothing(t)
+
+	/* Forwarded packets are not sent back to where they came from */
+	a.phy.TXSendPacket(1, 6, []byte{0x84})
+	b.expectPacket(t, 1, 6, []byte{0x84})
+	a.expectNothing(t)
+
+	br.Close()
+	expectReturn(t, result)
+}
+
+func TestBridgeLineFailure(t *testing.T) {
+	_, a, b, result := newBridge(t)
+
+	/* When one side fails, Run returns its error and stops forwarding */
+	b.phy.Close()
+	if err := expectReturn(t, result); err == nil {
+		t.Fatal("Run did not report the failure")
+	}
+
+	a.phy.TXSendPacket(1, 5, []byte{0x44})
+	a.expectNothing(t)
+}
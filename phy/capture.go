@@ -0,0 +1,127 @@
+package phy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+/* Capture file format (all integers are little endian):
+ *
+ * File header:
+ *   [4]byte  magic "BGCP"
+ *   uint16   version (currently 1)
+ *
+ * Followed by any number of records:
+ *   int64    timestamp in nanoseconds since the Unix epoch
+ *   uint8    direction (0 = received, 1 = transmitted)
+ *   uint16   length of the frame
+ *   []byte   raw frame as seen on the line, including escape bytes
+ */
+
+var (
+	captureMagic = [4]byte{'B', 'G', 'C', 'P'}
+
+	// ErrorCaptureFormat is returned when a capture file has an invalid header.
+	ErrorCaptureFormat = errors.New("Invalid capture file format")
+)
+
+const captureVersion = 1
+
+// Direction indicates if a frame was received or transmitted.
+type Direction uint8
+
+const (
+	// DirectionRX is used for frames that were received from the line.
+	DirectionRX Direction = 0
+	// DirectionTX is used for frames that were transmitted on the line.
+	DirectionTX Direction = 1
+)
+
+// CaptureWriter writes raw frames to a capture file. It is safe for concurrent use.
+type CaptureWriter struct {
+	sync.Mutex
+
+	w   io.Writer
+	buf []byte
+}
+
+// NewCaptureWriter creates a CaptureWriter and writes the file header to w.
+func NewCaptureWriter(w io.Writer) (*CaptureWriter, error) {
+	var header [6]byte
+	copy(header[:], captureMagic[:])
+	binary.LittleEndian.PutUint16(header[4:], captureVersion)
+
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+
+	return &CaptureWriter{w: w}, nil
+}
+
+// WriteFrame appends a single frame to the capture.
+func (c *CaptureWriter) WriteFrame(t time.Time, dir Direction, frame []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	var header [11]byte
+	binary.LittleEndian.PutUint64(header[0:], uint64(t.UnixNano()))
+	header[8] = byte(dir)
+	binary.LittleEndian.PutUint16(header[9:], uint16(len(frame)))
+
+	c.buf = append(append(c.buf[:0], header[:]...), frame...)
+	_, err := c.w.Write(c.buf)
+	return err
+}
+
+// CaptureFrame is a single record read from a capture file.
+type CaptureFrame struct {
+	Time      time.Time
+	Direction Direction
+	Frame     []byte
+}
+
+// CaptureReader reads frames from a capture file written by CaptureWriter.
+type CaptureReader struct {
+	r io.Reader
+}
+
+// NewCaptureReader creates a CaptureReader and validates the file header.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:4], captureMagic[:]) || binary.LittleEndian.Uint16(header[4:]) != captureVersion {
+		return nil, ErrorCaptureFormat
+	}
+
+	return &CaptureReader{r: r}, nil
+}
+
+// ReadFrame returns the next frame in the capture. It returns io.EOF at the end of the file.
+func (c *CaptureReader) ReadFrame() (CaptureFrame, error) {
+	var header [11]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return CaptureFrame{}, err
+	}
+
+	frame := CaptureFrame{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(header[0:]))),
+		Direction: Direction(header[8]),
+		Frame:     make([]byte, binary.LittleEndian.Uint16(header[9:])),
+	}
+
+	if _, err := io.ReadFull(c.r, frame.Frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return CaptureFrame{}, err
+	}
+
+	return frame, nil
+}
@@ -0,0 +1,103 @@
+package phy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	frames := []phy.CaptureFrame{
+		{Time: start, Direction: phy.DirectionTX, Frame: mustEncode(t, 1, 5, []byte{0x44})},
+		{Time: start.Add(3 * time.Millisecond), Direction: phy.DirectionRX, Frame: mustEncode(t, 5, 1, []byte{0x45, 0xAA, 0x01})},
+		{Time: start.Add(time.Second), Direction: phy.DirectionRX, Frame: []byte{}},
+	}
+
+	var buf bytes.Buffer
+	w, err := phy.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		if err := w.WriteFrame(f.Time, f.Direction, f.Frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := phy.NewCaptureReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range frames {
+		f, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("Frame %d: %v", i, err)
+		}
+		if !f.Time.Equal(expected.Time) || f.Direction != expected.Direction || !bytes.Equal(f.Frame, expected.Frame) {
+			t.Fatalf("Frame %d is %v %d % x, expected %v %d % x", i, f.Time, f.Direction, f.Frame, expected.Time, expected.Direction, expected.Frame)
+		}
+	}
+	if _, err := r.ReadFrame(); err != io.EOF {
+		t.Fatalf("Expected io.EOF at the end of the capture, got %v", err)
+	}
+}
+
+func TestCaptureTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := phy.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteFrame(time.Now(), phy.DirectionRX, []byte{1, 2, 3})
+
+	r, err := phy.NewCaptureReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadFrame(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	if _, err := phy.NewCaptureReader(bytes.NewReader([]byte("BGCX\x01\x00"))); !errors.Is(err, phy.ErrorCaptureFormat) {
+		t.Fatalf("Expected ErrorCaptureFormat, got %v", err)
+	}
+}
+
+func TestCaptureReceived(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := phy.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := newHarness(t, func(p *phy.PHY) {
+		p.Capture = w
+	})
+
+	frame := mustEncode(t, 5, 1, []byte{0x45, 0x01})
+	before := time.Now()
+	h.feed(t, frame)
+	h.expectPacket(t, 5, 1, []byte{0x45, 0x01})
+
+	w.Lock()
+	r, err := phy.NewCaptureReader(bytes.NewReader(buf.Bytes()))
+	w.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := r.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Direction != phy.DirectionRX || !bytes.Equal(f.Frame, frame) {
+		t.Fatalf("Captured %d % x, expected %d % x", f.Direction, f.Frame, phy.DirectionRX, frame)
+	}
+	if f.Time.Before(before.Add(-time.Second)) || f.Time.After(time.Now()) {
+		t.Fatalf("Captured timestamp %v is not close to %v", f.Time, before)
+	}
+}
@@ -0,0 +1,52 @@
+package phy
+
+// Checksum is a frame verification algorithm.
+type Checksum interface {
+	// Name returns a short description of the algorithm.
+	Name() string
+
+	// Compute returns the checksum of the unescaped frame, starting with the source address
+	// and ending with the last payload byte.
+	Compute(frame []byte) uint16
+}
+
+type checksumSum struct{}
+
+func (c checksumSum) Name() string {
+	return "sum"
+}
+
+func (c checksumSum) Compute(frame []byte) uint16 {
+	var sum uint16
+	for _, m := range frame {
+		sum += uint16(m)
+	}
+	return sum
+}
+
+// ChecksumSum is the standard BattGO checksum: the 16-bit sum of all frame bytes.
+var ChecksumSum Checksum = checksumSum{}
+
+/* Returns the first checksum that matches, or nil if none does */
+func (b *PHY) rxVerify(frame []byte, sum uint16) Checksum {
+	if len(b.RXChecksums) == 0 {
+		if ChecksumSum.Compute(frame) == sum {
+			return ChecksumSum
+		}
+		return nil
+	}
+
+	for _, m := range b.RXChecksums {
+		if m.Compute(frame) == sum {
+			return m
+		}
+	}
+	return nil
+}
+
+func (b *PHY) txChecksum() Checksum {
+	if b.TXChecksum == nil {
+		return ChecksumSum
+	}
+	return b.TXChecksum
+}
@@ -0,0 +1,75 @@
+package phy_test
+
+import (
+	"testing"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+type checksumXor struct{}
+
+func (c checksumXor) Name() string {
+	return "xor"
+}
+
+func (c checksumXor) Compute(frame []byte) uint16 {
+	var sum uint16
+	for i, m := range frame {
+		sum ^= uint16(m) << (8 * (i % 2))
+	}
+	return sum
+}
+
+/* transmitter returns a PHY that writes its frames into the line of the harness. Scrambling
+ * is disabled so the frames do not depend on the seed. */
+func (h *harness) transmitter(setup func(p *phy.PHY)) *phy.PHY {
+	tx := &phy.PHY{Port: pipePort{Writer: h.line, Closer: h.line}, TXDisableScrambler: true}
+	if setup != nil {
+		setup(tx)
+	}
+	return tx
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	var matched []string
+	h := newHarness(t, func(p *phy.PHY) {
+		p.RXDisableDescrambler = true
+		p.RXChecksums = []phy.Checksum{phy.ChecksumSum, checksumXor{}}
+		p.AddRXHook(func(packet *phy.Packet) bool {
+			matched = append(matched, packet.Checksum.Name())
+			return true
+		})
+	})
+
+	if err := h.transmitter(nil).TXSendPacket(5, 1, []byte{0x45, 0x10, 0x20}); err != nil {
+		t.Fatal(err)
+	}
+	h.expectPacket(t, 5, 1, []byte{0x45, 0x10, 0x20})
+
+	tx := h.transmitter(func(p *phy.PHY) {
+		p.TXChecksum = checksumXor{}
+	})
+	if err := tx.TXSendPacket(5, 1, []byte{0x45, 0x30, 0x40}); err != nil {
+		t.Fatal(err)
+	}
+	h.expectPacket(t, 5, 1, []byte{0x45, 0x30, 0x40})
+
+	if len(matched) != 2 || matched[0] != "sum" || matched[1] != "xor" {
+		t.Fatalf("Matched checksums %v, expected [sum xor]", matched)
+	}
+}
+
+func TestChecksumDefault(t *testing.T) {
+	h := newHarness(t, func(p *phy.PHY) {
+		p.RXDisableDescrambler = true
+	})
+
+	/* Only ChecksumSum is accepted when RXChecksums is not set */
+	tx := h.transmitter(func(p *phy.PHY) {
+		p.TXChecksum = checksumXor{}
+	})
+	if err := tx.TXSendPacket(5, 1, []byte{0x45, 0x30, 0x40}); err != nil {
+		t.Fatal(err)
+	}
+	h.expectError(t, phy.ErrorChecksum)
+}
@@ -0,0 +1,99 @@
+package phy
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrorCollision is returned when a packet could not be transmitted without collisions.
+	ErrorCollision = errors.New("Packet collided on the bus")
+)
+
+type collisionDetector struct {
+	sync.Mutex
+
+	expect []byte
+	result chan (bool)
+}
+
+func (c *collisionDetector) start(frame []byte) <-chan (bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.expect = frame
+	c.result = make(chan (bool), 1)
+	return c.result
+}
+
+func (c *collisionDetector) stop() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.expect = nil
+	c.result = nil
+}
+
+/* Called for every frame seen on the line, or with nil for a corrupted frame */
+func (c *collisionDetector) received(frame []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.result == nil {
+		return
+	}
+
+	c.result <- frame != nil && bytes.Equal(frame, c.expect)
+	c.result = nil
+}
+
+/* Writes the frame and retries with a random backoff until its echo is received intact */
+func (b *PHY) txWriteCollisionDetect(frame []byte) error {
+	retries := b.TXCollisionRetries
+	if retries == 0 {
+		retries = 3
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	timeout := b.TXCollisionEchoTimeout
+	if timeout == 0 {
+		timeout = 100 * time.Millisecond
+	}
+
+	backoff := b.TXCollisionBackoff
+	if backoff == 0 {
+		backoff = 20 * time.Millisecond
+	}
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 && backoff > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(backoff))))
+		}
+
+		result := b.collision.start(frame)
+		err := b.txWrite(frame)
+		if err != nil {
+			b.collision.stop()
+			return err
+		}
+
+		select {
+		case ok := <-result:
+			if ok {
+				return nil
+			}
+		case <-time.After(timeout):
+			b.collision.stop()
+		}
+
+		b.stats.update(func(stats *Stats) {
+			stats.Collisions++
+		})
+	}
+
+	return ErrorCollision
+}
@@ -0,0 +1,41 @@
+package phy_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func TestCollisionWithoutEcho(t *testing.T) {
+	for _, retries := range []int{-1, 2} {
+		p, port, err := phy.NewVirtualPair()
+		if err != nil {
+			t.Skipf("Virtual line not available: %v", err)
+		}
+
+		/* The other end never echoes, so every attempt is a collision */
+		p.TXCollisionDetect = true
+		p.TXCollisionRetries = retries
+		p.TXCollisionBackoff = -1
+		p.TXCollisionEchoTimeout = 20 * time.Millisecond
+		go p.Run()
+
+		err = p.TXSendPacket(1, 2, []byte{0x44})
+		if !errors.Is(err, phy.ErrorCollision) {
+			t.Errorf("Retries %d: expected ErrorCollision, got %v", retries, err)
+		}
+
+		attempts := uint64(retries + 1)
+		if retries < 0 {
+			attempts = 1
+		}
+		if collisions := p.Stats().Collisions; collisions != attempts {
+			t.Errorf("Retries %d: counted %d collisions, expected %d", retries, collisions, attempts)
+		}
+
+		p.Close()
+		port.Close()
+	}
+}
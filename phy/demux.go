@@ -0,0 +1,96 @@
+package phy
+
+import (
+	"sync"
+	"time"
+)
+
+// Demux allows several users, for example a controller and a passive monitor, to share one PHY.
+// Each user gets its own PHY from NewPHY(). Packets and presence signals received by the parent
+// are delivered to all of them. Frames they transmit are written to the parent one at a time,
+// so they never interleave on the line. Coordinating which user talks to which device is left
+// to the application.
+type Demux struct {
+	parent *PHY
+
+	childrenMutex sync.Mutex
+	children      []*PHY
+}
+
+// NewDemux creates a Demux on top of parent. It takes over RXHandlePresense of the parent and
+// adds an RX hook, RXHandlePacket of the parent can still be used.
+func NewDemux(parent *PHY) *Demux {
+	d := &Demux{
+		parent: parent,
+	}
+
+	parent.RXHandlePresense = d.presence
+	parent.AddRXHook(func(packet *Packet) bool {
+		d.deliver(packet)
+		return true
+	})
+
+	return d
+}
+
+// NewPHY returns a PHY that shares the line of the parent. It must be started with Run() like a
+// normal PHY. Closing it does not affect the parent or the other users.
+func (d *Demux) NewPHY() *PHY {
+	child := &PHY{
+		Port:        newMessagePort(0, d.parent.TXSendRaw, nil),
+		TXSendBreak: d.parent.SendBreak,
+	}
+
+	d.childrenMutex.Lock()
+	d.children = append(d.children, child)
+	d.childrenMutex.Unlock()
+
+	return child
+}
+
+// Run runs the parent PHY. It returns when the parent fails or Close() is called.
+func (d *Demux) Run() error {
+	return d.parent.Run()
+}
+
+// Close closes the parent PHY and all PHYs returned by NewPHY().
+func (d *Demux) Close() error {
+	for _, child := range d.active() {
+		child.Close()
+	}
+	return d.parent.Close()
+}
+
+/* Returns the children that have not been closed, and forgets the others */
+func (d *Demux) active() []*PHY {
+	d.childrenMutex.Lock()
+	defer d.childrenMutex.Unlock()
+
+	result := d.children[:0]
+	for _, child := range d.children {
+		if !child.closed.IsClosed() {
+			result = append(result, child)
+		}
+	}
+	d.children = result
+
+	return append([]*PHY(nil), result...)
+}
+
+func (d *Demux) deliver(packet *Packet) {
+	t := time.Now()
+	for _, child := range d.active() {
+		child.rxDeliver(t, packet.Checksum, packet.AddrSource, packet.AddrDest, packet.Payload)
+	}
+}
+
+func (d *Demux) presence(b byte) error {
+	for _, child := range d.active() {
+		if child.RXHandlePresense != nil {
+			if err := child.RXHandlePresense(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
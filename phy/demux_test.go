@@ -0,0 +1,54 @@
+package phy_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func TestDemux(t *testing.T) {
+	local, remote := newVirtualLine(t)
+	line := runHarness(t, &phy.PHY{Port: remote}, nil)
+
+	d := phy.NewDemux(local)
+	go d.Run()
+	t.Cleanup(func() {
+		d.Close()
+	})
+
+	a := runHarness(t, d.NewPHY(), nil)
+	b := runHarness(t, d.NewPHY(), nil)
+
+	/* Received packets are delivered to every user */
+	if err := line.phy.TXSendPacket(5, 1, []byte{0x45, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	a.expectPacket(t, 5, 1, []byte{0x45, 0x01})
+	b.expectPacket(t, 5, 1, []byte{0x45, 0x01})
+
+	/* Concurrent transmissions of both users arrive intact */
+	var wg sync.WaitGroup
+	for _, user := range []*harness{a, b} {
+		wg.Add(1)
+		go func(p *phy.PHY) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				p.TXSendPacket(1, 5, []byte{0x84, 0x00, 0x01, 0x02, 0x03})
+			}
+		}(user.phy)
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		line.expectPacket(t, 1, 5, []byte{0x84, 0x00, 0x01, 0x02, 0x03})
+	}
+	line.expectNothing(t)
+
+	/* Closing one user does not affect the others */
+	a.phy.Close()
+	if err := line.phy.TXSendPacket(6, 1, []byte{0x45, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	b.expectPacket(t, 6, 1, []byte{0x45, 0x02})
+	a.expectNothing(t)
+}
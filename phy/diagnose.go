@@ -0,0 +1,125 @@
+package phy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// LineQuality is the result of a line diagnosis.
+type LineQuality int
+
+const (
+	// LineQualityOK means valid frames were received.
+	LineQualityOK LineQuality = 0
+	// LineQualityNoData means nothing was received.
+	LineQualityNoData LineQuality = 1
+	// LineQualityInverted means the line looks like it is held low, which happens when the
+	// signal is inverted or shorted.
+	LineQualityInverted LineQuality = 2
+	// LineQualityWrongBaud means data was received that does not look like BattGO frames.
+	LineQualityWrongBaud LineQuality = 3
+	// LineQualityNoisy means frames were received but many were corrupted.
+	LineQualityNoisy LineQuality = 4
+)
+
+// LineDiagnosis describes the traffic observed during a diagnosis.
+type LineDiagnosis struct {
+	Quality LineQuality
+
+	Bytes        uint64
+	ZeroBytes    uint64
+	EscapeBytes  uint64
+	Frames       uint64
+	FrameErrors  uint64
+	ObservedTime time.Duration
+}
+
+func (d LineDiagnosis) String() string {
+	var assessment string
+	switch d.Quality {
+	case LineQualityOK:
+		assessment = "Line is working, valid frames were received"
+	case LineQualityNoData:
+		assessment = "No data received, check the wiring and whether a device is connected"
+	case LineQualityInverted:
+		assessment = "Line appears to be held low, the signal may be inverted or shorted"
+	case LineQualityWrongBaud:
+		assessment = "Data received but no frames recognized, the baud rate may be wrong"
+	case LineQualityNoisy:
+		assessment = "Many corrupted frames, the line is noisy or the signal level is too weak"
+	}
+
+	return fmt.Sprintf("%s (%d bytes, %d frames, %d errors in %v)", assessment, d.Bytes, d.Frames, d.FrameErrors, d.ObservedTime)
+}
+
+type diagCollector struct {
+	sync.Mutex
+
+	active    bool
+	bytes     uint64
+	zeroBytes uint64
+	escapes   uint64
+}
+
+func (c *diagCollector) observe(data []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	if !c.active {
+		return
+	}
+
+	c.bytes += uint64(len(data))
+	for _, m := range data {
+		if m == 0 {
+			c.zeroBytes++
+		} else if m == 0xAA {
+			c.escapes++
+		}
+	}
+}
+
+// Diagnose observes the received traffic for the given duration and returns an assessment
+// of the line. Run() must be active. To get a useful result a device should be talking,
+// for example because a controller is polling it.
+func (b *PHY) Diagnose(duration time.Duration) LineDiagnosis {
+	b.diag.Lock()
+	b.diag.active = true
+	b.diag.bytes, b.diag.zeroBytes, b.diag.escapes = 0, 0, 0
+	b.diag.Unlock()
+
+	before := b.Stats()
+	time.Sleep(duration)
+	after := b.Stats()
+
+	b.diag.Lock()
+	b.diag.active = false
+	result := LineDiagnosis{
+		Bytes:        b.diag.bytes,
+		ZeroBytes:    b.diag.zeroBytes,
+		EscapeBytes:  b.diag.escapes,
+		Frames:       after.FramesRX - before.FramesRX,
+		ObservedTime: duration,
+	}
+	b.diag.Unlock()
+
+	result.FrameErrors = (after.ChecksumErrors - before.ChecksumErrors) +
+		(after.FramingErrors - before.FramingErrors) +
+		(after.EscapeErrors - before.EscapeErrors)
+
+	switch {
+	case result.Bytes == 0:
+		result.Quality = LineQualityNoData
+	case result.ZeroBytes*10 >= result.Bytes*9:
+		result.Quality = LineQualityInverted
+	case result.Frames == 0:
+		result.Quality = LineQualityWrongBaud
+	case result.FrameErrors*4 > result.Frames:
+		result.Quality = LineQualityNoisy
+	default:
+		result.Quality = LineQualityOK
+	}
+
+	return result
+}
@@ -0,0 +1,110 @@
+package phy
+
+import (
+	"sync"
+	"time"
+)
+
+// DiscoveredPort describes a serial port on which BattGO traffic was observed.
+type DiscoveredPort struct {
+	// PortName is the path of the serial device.
+	PortName string
+
+	// DeviceSeen is true when a device answered the broadcast ping.
+	DeviceSeen bool
+
+	// EchoSeen is true when the ping itself was received back, as happens with adapters
+	// that have TX and RX connected together.
+	EchoSeen bool
+}
+
+func discoverProbe(options *SerialOptions, timeout time.Duration) (DiscoveredPort, error) {
+	result := DiscoveredPort{
+		PortName: options.PortName,
+	}
+
+	phy, err := NewSerial(options)
+	if err != nil {
+		return result, err
+	}
+
+	var mutex sync.Mutex
+	deviceChan := make(chan (struct{}), 1)
+
+	phy.RXHandlePacket = func(addrSource uint8, addrDest uint8, payload []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if addrSource == 1 && addrDest == 0 {
+			result.EchoSeen = true
+		} else if addrDest == 1 {
+			result.DeviceSeen = true
+			select {
+			case deviceChan <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}
+
+	runDone := make(chan (struct{}))
+	go func() {
+		phy.Run()
+		close(runDone)
+	}()
+
+	if phy.TXSendBreak != nil {
+		phy.SendBreak(200 * time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	cmdPingAll := [12]byte{2}
+	err = phy.TXSendPacket(1, 0, cmdPingAll[:])
+
+	if err == nil {
+		select {
+		case <-deviceChan:
+		case <-time.After(timeout):
+		}
+	}
+
+	phy.Close()
+	<-runDone
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return result, err
+}
+
+// DiscoverSerial probes all candidate serial ports of the system with a broadcast ping and
+// returns the ports on which a device or the echo of the ping was seen. The PortName in
+// options is ignored, the other fields are used to open each port. When timeout is 0 a
+// reasonable default is used.
+func DiscoverSerial(options *SerialOptions, timeout time.Duration) ([]DiscoveredPort, error) {
+	if timeout == 0 {
+		timeout = 500 * time.Millisecond
+	}
+
+	candidates, err := serialCandidatePorts()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []DiscoveredPort
+	for _, portName := range candidates {
+		opts := *options
+		opts.PortName = portName
+		opts.Reconnect = false
+
+		port, err := discoverProbe(&opts, timeout)
+		if err != nil {
+			continue
+		}
+
+		if port.DeviceSeen || port.EchoSeen {
+			result = append(result, port)
+		}
+	}
+
+	return result, nil
+}
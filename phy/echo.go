@@ -0,0 +1,56 @@
+package phy
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+/* Transmitted frames are only matched against echoes for this long */
+const echoTimeout = 500 * time.Millisecond
+
+type echoEntry struct {
+	frame []byte
+	sent  time.Time
+}
+
+type echoFilter struct {
+	sync.Mutex
+	pending []echoEntry
+}
+
+func (e *echoFilter) expire(now time.Time) {
+	i := 0
+	for i < len(e.pending) && now.Sub(e.pending[i].sent) > echoTimeout {
+		i++
+	}
+	e.pending = e.pending[i:]
+}
+
+func (e *echoFilter) transmitted(frame []byte) {
+	e.Lock()
+	defer e.Unlock()
+
+	now := time.Now()
+	e.expire(now)
+	e.pending = append(e.pending, echoEntry{
+		frame: append([]byte(nil), frame...),
+		sent:  now,
+	})
+}
+
+/* Returns true if the frame matches a transmitted frame, which is then forgotten */
+func (e *echoFilter) received(frame []byte) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	e.expire(time.Now())
+	for i, m := range e.pending {
+		if bytes.Equal(m.frame, frame) {
+			e.pending = append(e.pending[:i], e.pending[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
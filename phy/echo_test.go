@@ -0,0 +1,65 @@
+package phy_test
+
+import (
+	"testing"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func TestEchoFilter(t *testing.T) {
+	local, remote := newVirtualLine(t)
+	h := runHarness(t, local, func(p *phy.PHY) {
+		p.RXFilterEcho = true
+	})
+
+	/* The remote end behaves like a half-duplex line that receives its own transmissions */
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := remote.Read(buf)
+			if err != nil {
+				return
+			}
+			remote.Write(buf[:n])
+		}
+	}()
+
+	if err := local.TXSendPacket(1, 5, []byte{0x84}); err != nil {
+		t.Fatal(err)
+	}
+	h.expectNothing(t)
+
+	/* Packets of other talkers are delivered, also when the same bytes were sent before */
+	reply := mustEncode(t, 5, 1, []byte{0x85, 0x01})
+	if _, err := remote.Write(reply); err != nil {
+		t.Fatal(err)
+	}
+	h.expectPacket(t, 5, 1, []byte{0x85, 0x01})
+
+	if err := local.TXSendPacket(1, 5, []byte{0x84}); err != nil {
+		t.Fatal(err)
+	}
+	h.expectNothing(t)
+	if _, err := remote.Write(reply); err != nil {
+		t.Fatal(err)
+	}
+	h.expectPacket(t, 5, 1, []byte{0x85, 0x01})
+}
+
+func TestEchoFilterDisabled(t *testing.T) {
+	local, remote := newVirtualLine(t)
+	h := runHarness(t, local, nil)
+
+	go func() {
+		buf := make([]byte, 256)
+		n, err := remote.Read(buf)
+		if err == nil {
+			remote.Write(buf[:n])
+		}
+	}()
+
+	if err := local.TXSendPacket(1, 5, []byte{0x84}); err != nil {
+		t.Fatal(err)
+	}
+	h.expectPacket(t, 1, 5, []byte{0x84})
+}
@@ -0,0 +1,29 @@
+package phy
+
+import (
+	"net"
+	"os"
+)
+
+// NewFromFD sets the PHY up for an already opened descriptor, for example one passed in by
+// systemd socket activation or by a privileged helper process. When the descriptor is a
+// terminal, breaks are sent using the terminal driver.
+func NewFromFD(fd uintptr) (*PHY, error) {
+	file := os.NewFile(fd, "fd")
+	if file == nil {
+		return nil, os.ErrInvalid
+	}
+
+	return &PHY{
+		Port:        file,
+		TXSendBreak: serialFileBreak(file),
+	}, nil
+}
+
+// NewFromConn sets the PHY up for a stream connection, such as a TCP connection to a
+// serial server. Breaks cannot be sent over a connection, so TXSendBreak is left empty.
+func NewFromConn(conn net.Conn) *PHY {
+	return &PHY{
+		Port: conn,
+	}
+}
@@ -0,0 +1,45 @@
+package phy
+
+import (
+	"sync"
+	"time"
+)
+
+type gapTracker struct {
+	sync.Mutex
+
+	lastRX time.Time
+	lastTX time.Time
+}
+
+func (g *gapTracker) received(t time.Time) {
+	g.Lock()
+	defer g.Unlock()
+
+	g.lastRX = t
+}
+
+func (g *gapTracker) transmitted() {
+	g.Lock()
+	defer g.Unlock()
+
+	g.lastTX = time.Now()
+}
+
+/* Sleeps until the configured idle times since the last transmission and reception have passed */
+func (g *gapTracker) wait(minGap time.Duration, gapAfterRX time.Duration) {
+	if minGap <= 0 && gapAfterRX <= 0 {
+		return
+	}
+
+	g.Lock()
+	deadline := g.lastTX.Add(minGap)
+	if rx := g.lastRX.Add(gapAfterRX); rx.After(deadline) {
+		deadline = rx
+	}
+	g.Unlock()
+
+	if d := time.Until(deadline); d > 0 {
+		time.Sleep(d)
+	}
+}
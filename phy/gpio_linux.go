@@ -0,0 +1,83 @@
+package phy
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/* Linux GPIO character device ABI (linux/gpio.h, v1) */
+const (
+	gpioHandlesMax            = 64
+	gpioHandleRequestOutput   = 1 << 1
+	gpioGetLineHandleIoctl    = 0xC16CB403
+	gpioHandleSetValuesIoctl  = 0xC040B409
+	gpioConsumerLabelMaxBytes = 32
+)
+
+type gpioHandleRequest struct {
+	lineOffsets   [gpioHandlesMax]uint32
+	flags         uint32
+	defaultValues [gpioHandlesMax]uint8
+	consumerLabel [gpioConsumerLabelMaxBytes]byte
+	lines         uint32
+	fd            int32
+}
+
+type gpioHandleData struct {
+	values [gpioHandlesMax]uint8
+}
+
+func gpioRequestOutput(chip string, line int, value uint8) (int, error) {
+	file, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return -1, err
+	}
+	defer file.Close()
+
+	req := gpioHandleRequest{
+		flags: gpioHandleRequestOutput,
+		lines: 1,
+	}
+	req.lineOffsets[0] = uint32(line)
+	req.defaultValues[0] = value
+	copy(req.consumerLabel[:], "battgo-break")
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), gpioGetLineHandleIoctl, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return -1, os.NewSyscallError("GPIO_GET_LINEHANDLE_IOCTL", errno)
+	}
+
+	return int(req.fd), nil
+}
+
+func gpioSetValue(fd int, value uint8) error {
+	data := gpioHandleData{}
+	data.values[0] = value
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), gpioHandleSetValuesIoctl, uintptr(unsafe.Pointer(&data)))
+	if errno != 0 {
+		return os.NewSyscallError("GPIOHANDLE_SET_LINE_VALUES_IOCTL", errno)
+	}
+	return nil
+}
+
+func serialBreakGPIO(chip string, line int) (func(d time.Duration) error, error) {
+	if chip == "" || line < 0 {
+		return nil, ErrorSerialOption
+	}
+
+	return func(d time.Duration) error {
+		/* The line is requested low, so the break starts immediately */
+		fd, err := gpioRequestOutput(chip, line, 0)
+		if err != nil {
+			return err
+		}
+		defer unix.Close(fd)
+
+		time.Sleep(d)
+		return gpioSetValue(fd, 1)
+	}, nil
+}
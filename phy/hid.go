@@ -0,0 +1,79 @@
+package phy
+
+import (
+	"io"
+	"time"
+)
+
+// HIDOptions contains the parameters used by NewHID.
+//
+// Data is carried in reports where the report ID holds the number of valid data bytes that
+// follow, the layout used by common HID UART bridges. Reports with an ID that cannot be a
+// valid length are vendor specific and passed to HandleVendorReport.
+type HIDOptions struct {
+	// Device is the opened HID device. Read must return a single input report and Write must
+	// send a single output report, both starting with the report ID. This matches the API of
+	// most Go HID libraries.
+	Device io.ReadWriteCloser
+
+	// ReportSize is the size of a report including the report ID. Defaults to 64.
+	ReportSize int
+
+	// SendBreak is an optional function that performs the vendor specific break request.
+	SendBreak func(d time.Duration) error
+
+	// HandleVendorReport is an optional callback for input reports that do not carry data,
+	// for example presence notifications.
+	HandleVendorReport func(report []byte) error
+}
+
+// NewHID sets the PHY up for an adapter that enumerates as a USB HID device.
+func NewHID(options *HIDOptions) (*PHY, error) {
+	reportSize := options.ReportSize
+	if reportSize == 0 {
+		reportSize = 64
+	}
+
+	dev := options.Device
+	report := make([]byte, reportSize)
+
+	port := newMessagePort(reportSize-1, func(p []byte) error {
+		report[0] = byte(len(p))
+		copy(report[1:], p)
+		for i := 1 + len(p); i < len(report); i++ {
+			report[i] = 0
+		}
+
+		_, err := dev.Write(report)
+		return err
+	}, dev.Close)
+
+	go func() {
+		defer port.Close()
+
+		buf := make([]byte, reportSize)
+		for {
+			n, err := dev.Read(buf)
+			if err != nil {
+				return
+			}
+			if n == 0 {
+				continue
+			}
+
+			length := int(buf[0])
+			if length < n {
+				port.deliver(buf[1 : 1+length])
+			} else if options.HandleVendorReport != nil {
+				if options.HandleVendorReport(buf[:n]) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return &PHY{
+		Port:        port,
+		TXSendBreak: options.SendBreak,
+	}, nil
+}
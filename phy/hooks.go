@@ -0,0 +1,53 @@
+package phy
+
+import "sync"
+
+// Packet is a decoded packet as seen by hooks.
+type Packet struct {
+	AddrSource uint8
+	AddrDest   uint8
+	Payload    []byte
+
+	// Checksum is the algorithm that verified a received packet. It is nil for transmitted packets.
+	Checksum Checksum
+}
+
+// Hook is a function that is called for every packet. It may modify the packet. When it
+// returns false the packet is dropped and later hooks are not called.
+type Hook func(packet *Packet) bool
+
+type hookChain struct {
+	sync.RWMutex
+	hooks []Hook
+}
+
+func (h *hookChain) add(hook Hook) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.hooks = append(h.hooks, hook)
+}
+
+func (h *hookChain) run(packet *Packet) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, m := range h.hooks {
+		if !m(packet) {
+			return false
+		}
+	}
+	return true
+}
+
+// AddRXHook adds a hook that is called for every received packet before RXHandlePacket.
+// Hooks are called in the order they were added.
+func (b *PHY) AddRXHook(hook Hook) {
+	b.rxHooks.add(hook)
+}
+
+// AddTXHook adds a hook that is called for every packet passed to TXSendPacket before it is
+// encoded. Hooks are called in the order they were added.
+func (b *PHY) AddTXHook(hook Hook) {
+	b.txHooks.add(hook)
+}
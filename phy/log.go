@@ -0,0 +1,14 @@
+package phy
+
+import (
+	"log/slog"
+
+	"github.com/BertoldVdb/go-battgo/internal/logging"
+)
+
+func (b *PHY) log() *slog.Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+	return logging.Discard
+}
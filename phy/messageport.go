@@ -0,0 +1,84 @@
+package phy
+
+import (
+	"sync"
+)
+
+/* messagePort turns a message based transport into the byte stream expected by the PHY */
+type messagePort struct {
+	write func(p []byte) error
+	close func() error
+	mtu   int
+
+	rxChan  chan ([]byte)
+	pending []byte
+
+	closeOnce sync.Once
+	closeChan chan (struct{})
+}
+
+func newMessagePort(mtu int, write func(p []byte) error, close func() error) *messagePort {
+	return &messagePort{
+		write:     write,
+		close:     close,
+		mtu:       mtu,
+		rxChan:    make(chan ([]byte), 64),
+		closeChan: make(chan (struct{})),
+	}
+}
+
+/* deliver queues a received message. It is safe to call from any goroutine. */
+func (m *messagePort) deliver(buf []byte) {
+	select {
+	case m.rxChan <- append([]byte(nil), buf...):
+	case <-m.closeChan:
+	}
+}
+
+func (m *messagePort) Read(p []byte) (int, error) {
+	for len(m.pending) == 0 {
+		select {
+		case m.pending = <-m.rxChan:
+		case <-m.closeChan:
+			return 0, ErrorPortClosed
+		}
+	}
+
+	n := copy(p, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+func (m *messagePort) Write(p []byte) (int, error) {
+	select {
+	case <-m.closeChan:
+		return 0, ErrorPortClosed
+	default:
+	}
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if m.mtu > 0 && len(chunk) > m.mtu {
+			chunk = chunk[:m.mtu]
+		}
+
+		if err := m.write(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+
+	return written, nil
+}
+
+func (m *messagePort) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.closeChan)
+		if m.close != nil {
+			err = m.close()
+		}
+	})
+	return err
+}
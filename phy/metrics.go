@@ -0,0 +1,20 @@
+package phy
+
+import "time"
+
+// Metrics is an interface that can be implemented by telemetry systems to observe the PHY.
+// The methods are called synchronously from the receive and transmit paths, so they should
+// return quickly.
+type Metrics interface {
+	// OnFrameRX is called for every frame received with a valid checksum.
+	OnFrameRX(addrSource uint8, addrDest uint8, payloadLength int)
+
+	// OnFrameTX is called for every frame that was written to the port.
+	OnFrameTX(addrSource uint8, addrDest uint8, payloadLength int)
+
+	// OnError is called for every malformed frame and for failed transmissions.
+	OnError(err error)
+
+	// OnBreak is called for every break sent with SendBreak.
+	OnBreak(d time.Duration)
+}
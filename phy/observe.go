@@ -0,0 +1,59 @@
+package phy
+
+import (
+	"sync"
+	"time"
+)
+
+// AddressPair is a combination of source and destination address seen on the bus.
+type AddressPair struct {
+	Source uint8
+	Dest   uint8
+}
+
+// AddressStats describes the traffic seen for an AddressPair.
+type AddressStats struct {
+	Count    uint64
+	LastSeen time.Time
+}
+
+type addressObserver struct {
+	sync.Mutex
+	pairs map[AddressPair]AddressStats
+}
+
+func (o *addressObserver) observe(t time.Time, addrSource uint8, addrDest uint8) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.pairs == nil {
+		o.pairs = make(map[AddressPair]AddressStats)
+	}
+
+	pair := AddressPair{Source: addrSource, Dest: addrDest}
+	stats := o.pairs[pair]
+	stats.Count++
+	stats.LastSeen = t
+	o.pairs[pair] = stats
+}
+
+// ObservedAddresses returns every address pair seen in a valid frame on the bus, including
+// traffic between other devices and the echo of our own transmissions.
+func (b *PHY) ObservedAddresses() map[AddressPair]AddressStats {
+	b.observer.Lock()
+	defer b.observer.Unlock()
+
+	result := make(map[AddressPair]AddressStats, len(b.observer.pairs))
+	for k, v := range b.observer.pairs {
+		result[k] = v
+	}
+	return result
+}
+
+// ResetObservedAddresses forgets all address pairs seen so far.
+func (b *PHY) ResetObservedAddresses() {
+	b.observer.Lock()
+	defer b.observer.Unlock()
+
+	b.observer.pairs = nil
+}
@@ -1,39 +1,194 @@
 package phy
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"io"
+	"log/slog"
+	"math/rand"
+	"sync"
 	"time"
 
-	"github.com/BertoldVdb/go-misc/serial"
+	"github.com/BertoldVdb/go-misc/closeflag"
+)
+
+var (
+	// ErrorChecksum is reported when a frame has an invalid checksum.
+	ErrorChecksum = errors.New("Frame has an invalid checksum")
+	// ErrorLength is reported when a frame has an invalid length field.
+	ErrorLength = errors.New("Frame has an invalid length")
+	// ErrorOversize is reported when a frame is longer than RXMaxPayloadLength.
+	ErrorOversize = errors.New("Frame payload is too long")
+	// ErrorFrameInterrupted is reported when a frame was interrupted by the start of a new frame.
+	ErrorFrameInterrupted = errors.New("Frame was interrupted")
+	// ErrorFrameTimeout is reported when the line was idle for longer than RXIdleTimeout in the
+	// middle of a frame.
+	ErrorFrameTimeout = errors.New("Frame timed out")
+)
+
+// SeedMode selects how the scrambler seed is chosen for outgoing packets.
+type SeedMode int
+
+const (
+	// SeedIncrement starts at zero and increments the seed for every packet.
+	SeedIncrement SeedMode = 0
+	// SeedFixed uses TXSeed for every packet.
+	SeedFixed SeedMode = 1
+	// SeedRandom uses a random seed for every packet.
+	SeedRandom SeedMode = 2
 )
 
 // PHY implements functions for receiving and transmitting data to ISDT BattGO devices.
 type PHY struct {
-	// Port is the device used to communicate with the target.
+	// Port is the device used to communicate with the target. Use SetPort to change it while
+	// Run() is active.
 	Port io.ReadWriteCloser
 
 	// RXHandlePresense is an optional callback that is called when a target device may be present.
 	RXHandlePresense func(byte) error
 
 	// RXHandlePacket is a callback that is called each time a valid packet is received. Please note
-	// that, depending on your hardware configuration, you may receive your own packets.
+	// that, depending on your hardware configuration, you may receive your own packets unless
+	// RXFilterEcho is set.
 	RXHandlePacket func(addrSource uint8, addrDest uint8, payload []byte) error
 
+	// RXHandlePacketTimestamp is an optional callback that is called after RXHandlePacket. It also
+	// receives the time at which the last byte of the packet was read from the port. The time
+	// contains a monotonic clock reading, so it can be used for latency measurements.
+	RXHandlePacketTimestamp func(t time.Time, addrSource uint8, addrDest uint8, payload []byte) error
+
+	// RXHandleError is an optional callback that is called when a malformed frame is discarded.
+	// The error is one of ErrorChecksum, ErrorLength, ErrorOversize, ErrorFrameInterrupted or
+	// ErrorFrameTimeout, raw contains the bytes of the frame as seen on the line. Returning an error stops Run().
+	RXHandleError func(err error, raw []byte) error
+
+	// RXMaxPayloadLength is the largest payload that is accepted. Longer frames are discarded
+	// as soon as their header is received. Zero means no limit.
+	RXMaxPayloadLength int
+
+	// RXIdleTimeout resets the receiver when no data arrives for this long in the middle of a
+	// frame, so a lost byte does not keep it out of sync. Zero disables the timeout.
+	RXIdleTimeout time.Duration
+
+	// RXFilterEcho suppresses received packets that are identical to a packet that was just
+	// transmitted. Enable this when the hardware receives its own transmissions.
+	RXFilterEcho bool
+
+	// RXDisableDescrambler passes received payloads on without descrambling them. This is needed
+	// for devices that send plaintext payloads.
+	RXDisableDescrambler bool
+
+	// RXScrambleValidate enables autodetection of the scrambling used by each device. It is
+	// called with candidate descrambled payloads and should return true if the payload looks
+	// valid. The variant that was accepted is remembered per source address.
+	RXScrambleValidate func(addrSource uint8, payload []byte) bool
+
+	// RXScrambleVariants are the variants tried by autodetection, in order. Defaults to
+	// ScrambleStandard followed by ScramblePlain.
+	RXScrambleVariants []ScrambleVariant
+
+	// RXChecksums is the list of checksum algorithms that are tried, in order, on received frames.
+	// The algorithm that matched is reported to RX hooks. Defaults to ChecksumSum only.
+	RXChecksums []Checksum
+
+	// TXChecksum is the checksum algorithm used for transmitted frames. Defaults to ChecksumSum.
+	TXChecksum Checksum
+
 	// TXDisableScrambler disables scrambling on outgoing packets when set.
 	TXDisableScrambler bool
 
+	// TXSeedMode selects how the scrambler seed of outgoing packets is chosen.
+	TXSeedMode SeedMode
+
+	// TXSeed is the seed used when TXSeedMode is SeedFixed.
+	TXSeed uint8
+
 	// TXSendBreak is a callback that should send a low state on the serial line for at least
 	// the given duration. If your hardware does not allow duration control, ensure it is at
 	// least 70ms.
 	TXSendBreak func(t time.Duration) error
 
-	txBuf  []byte
-	txSeed uint8
+	// TXMinGap is the minimum idle time between the end of a transmission and the start of the next.
+	TXMinGap time.Duration
+
+	// TXGapAfterRX is the minimum idle time between receiving a packet and starting a transmission.
+	TXGapAfterRX time.Duration
+
+	// TXRateLimit is the maximum number of packets per second sent to a single destination
+	// address. Zero disables rate limiting.
+	TXRateLimit float64
+
+	// TXRateBurst is the number of packets that may be sent to a destination back-to-back before
+	// the rate limit applies. Defaults to 1.
+	TXRateBurst int
+
+	// TXRateReject makes TXSendPacket return ErrorRateLimited instead of waiting when the rate
+	// limit is exceeded.
+	TXRateReject bool
+
+	// TXCollisionDetect verifies that each transmitted packet is received back intact. When it is
+	// corrupted by another talker, the packet is retransmitted after a random backoff. This
+	// requires hardware that receives its own transmissions.
+	TXCollisionDetect bool
+
+	// TXCollisionRetries is the number of retransmissions before TXSendPacket returns
+	// ErrorCollision. Defaults to 3 when zero, a negative value disables retransmissions.
+	TXCollisionRetries int
+
+	// TXCollisionEchoTimeout is the time to wait for the echo of a packet. Defaults to 100ms.
+	TXCollisionEchoTimeout time.Duration
+
+	// TXCollisionBackoff is the maximum random delay before a retransmission. Defaults to 20ms
+	// when zero, a negative value retransmits without delay.
+	TXCollisionBackoff time.Duration
+
+	// Metrics is an optional interface that is informed of all traffic and errors.
+	Metrics Metrics
+
+	// Capture is an optional writer that receives every raw frame that is received or transmitted.
+	Capture *CaptureWriter
+
+	// Logger is an optional logger. Malformed frames are logged at debug level, transmit
+	// failures as warnings.
+	Logger *slog.Logger
+
+	stats     statsCounter
+	echo      echoFilter
+	collision collisionDetector
+	gap       gapTracker
+	rxHooks   hookChain
+	txHooks   hookChain
+
+	rateLimiter rateLimiter
+	diag        diagCollector
+	observer    addressObserver
+	scrambler   scrambleDetector
+
+	rxOnce sync.Once
+	rxChan chan (rxChunk)
+	rxErr  error
+
+	closed closeflag.CloseFlag
+
+	portMutex      sync.Mutex
+	portGeneration uint64
+
+	txQueueOnce sync.Once
+	txQueue     chan (txRequest)
+
+	txMutex sync.Mutex
+	txBuf   []byte
+	txFrame []byte
+	txSeed  uint8
 }
 
 func scramble(seed uint8, out []byte, in []byte) {
-	xor := seed + 136
+	scrambleOffset(seed, 136, out, in)
+}
+
+func scrambleOffset(seed uint8, offset uint8, out []byte, in []byte) {
+	xor := seed + offset
 
 	for i := range in {
 		out[i] = in[i] ^ xor
@@ -43,28 +198,107 @@ func scramble(seed uint8, out []byte, in []byte) {
 	}
 }
 
+type rxChunk struct {
+	data []byte
+	t    time.Time
+}
+
+/* The port is read by a separate goroutine so RunContext can return without closing it */
+func (b *PHY) rxStart() {
+	b.rxOnce.Do(func() {
+		b.rxChan = make(chan (rxChunk), 16)
+
+		go func() {
+			defer close(b.rxChan)
+
+			var rxBuf [512]byte
+			for {
+				port, generation := b.port()
+				n, err := port.Read(rxBuf[:])
+				if n > 0 {
+					b.rxChan <- rxChunk{
+						data: append([]byte(nil), rxBuf[:n]...),
+						t:    time.Now(),
+					}
+				}
+				if err != nil {
+					/* The port was replaced by SetPort, continue on the new one */
+					if b.portChanged(generation) && !b.closed.IsClosed() {
+						continue
+					}
+
+					b.rxErr = err
+					return
+				}
+			}
+		}()
+	})
+}
+
 // Run needs to be called to start listening for packets on the line. It will return
 // when there is an error or Close() is called.
 func (b *PHY) Run() error {
 	defer b.Close()
 
+	return b.RunContext(context.Background())
+}
+
+// RunContext is like Run, but it also returns when the context is cancelled. In that case
+// the port is left open and RunContext can be called again later. Data received while
+// RunContext is not running is processed by the next call.
+func (b *PHY) RunContext(ctx context.Context) error {
+	b.rxStart()
+
 	rxState := 0
 	rxLen := 0
 
 	var addrSource, addrDest uint8
-	var sum uint16
+	var rxLenByte uint8
 	var payload []byte
-	var rxBuf [512]byte
+	var rxFrame []byte
+	var rxRaw []byte
 	var isEscaped bool
+	var rxLast time.Time
 
 	for {
-		n, err := b.Port.Read(rxBuf[:])
-		if err != nil {
-			return err
+		var chunk rxChunk
+		var ok bool
+
+		select {
+		case chunk, ok = <-b.rxChan:
+			if !ok {
+				return b.rxErr
+			}
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		message := rxBuf[:n]
+		message := chunk.data
+
+		if b.RXIdleTimeout > 0 && rxState != 0 && chunk.t.Sub(rxLast) > b.RXIdleTimeout {
+			b.stats.update(func(stats *Stats) {
+				stats.PartialFrames++
+			})
+			b.collision.received(nil)
+
+			err := b.rxError(ErrorFrameTimeout, rxRaw)
+			if err != nil {
+				return err
+			}
+
+			rxState = 0
+			isEscaped = false
+			rxRaw = rxRaw[:0]
+		}
+		rxLast = chunk.t
+
+		b.stats.update(func(stats *Stats) {
+			stats.BytesRX += uint64(len(message))
+		})
+		b.diag.observe(message)
 
 		for _, m := range message {
+			rxRaw = append(rxRaw, m)
+
 			if !isEscaped {
 				if m == 0xAA {
 					isEscaped = true
@@ -73,13 +307,27 @@ func (b *PHY) Run() error {
 			} else {
 				isEscaped = false
 				if m != 0xAA {
+					if rxState != 0 {
+						b.stats.update(func(stats *Stats) {
+							stats.EscapeErrors++
+							stats.PartialFrames++
+						})
+						b.collision.received(nil)
+
+						err := b.rxError(ErrorFrameInterrupted, rxRaw[:len(rxRaw)-2])
+						if err != nil {
+							return err
+						}
+					}
+
 					rxState = 1
-					sum = 0
+					rxRaw = append(rxRaw[:0], 0xAA, m)
 				}
 			}
 
 			switch rxState {
 			case 0:
+				rxRaw = rxRaw[:0]
 				if b.RXHandlePresense != nil {
 					err := b.RXHandlePresense(m)
 					if err != nil {
@@ -88,39 +336,81 @@ func (b *PHY) Run() error {
 				}
 			case 1:
 				addrSource = m
-				sum += uint16(m)
 				rxState = 2
 			case 2:
 				addrDest = m
-				sum += uint16(m)
 				rxState = 3
 			case 3:
-				if m > 0 {
+				rxState = 0
+				if m == 0 {
+					b.stats.update(func(stats *Stats) {
+						stats.FramingErrors++
+					})
+					b.collision.received(nil)
+
+					err := b.rxError(ErrorLength, rxRaw)
+					if err != nil {
+						return err
+					}
+				} else if b.RXMaxPayloadLength > 0 && int(m)-1 > b.RXMaxPayloadLength {
+					b.stats.update(func(stats *Stats) {
+						stats.FramingErrors++
+					})
+					b.collision.received(nil)
+
+					err := b.rxError(ErrorOversize, rxRaw)
+					if err != nil {
+						return err
+					}
+				} else {
 					payload = payload[:0]
 					rxLen = int(m) + 2
-					sum += uint16(m)
+					rxLenByte = m
 					rxState = 4
-				} else {
-					rxState = 0
 				}
 			case 4:
 				payload = append(payload, m)
 				if len(payload) == rxLen {
-					for i := 0; i < len(payload)-2; i++ {
-						sum += uint16(payload[i])
+					if b.Capture != nil {
+						b.Capture.WriteFrame(chunk.t, DirectionRX, rxRaw)
 					}
+					b.collision.received(rxRaw)
+					b.gap.received(chunk.t)
 
 					/* Checksum valid? */
 					csumEnd := len(payload) - 2
-					if binary.LittleEndian.Uint16(payload[csumEnd:]) == sum {
-						scramble(payload[0], payload[1:], payload[1:])
+					rxFrame = append(append(rxFrame[:0], addrSource, addrDest, rxLenByte), payload[:csumEnd]...)
+					checksum := b.rxVerify(rxFrame, binary.LittleEndian.Uint16(payload[csumEnd:]))
+					if checksum != nil {
+						b.stats.update(func(stats *Stats) {
+							stats.FramesRX++
+						})
+						if b.Metrics != nil {
+							b.Metrics.OnFrameRX(addrSource, addrDest, csumEnd-1)
+						}
+						b.observer.observe(chunk.t, addrSource, addrDest)
 
-						if b.RXHandlePacket != nil {
-							err := b.RXHandlePacket(addrSource, addrDest, payload[1:csumEnd])
+						if !b.RXDisableDescrambler {
+							b.rxDescramble(addrSource, payload[0], payload[1:csumEnd])
+						}
+
+						if b.RXFilterEcho && b.echo.received(rxRaw) {
+							/* Our own transmission */
+						} else {
+							err := b.rxDeliver(chunk.t, checksum, addrSource, addrDest, payload[1:csumEnd])
 							if err != nil {
 								return err
 							}
 						}
+					} else {
+						b.stats.update(func(stats *Stats) {
+							stats.ChecksumErrors++
+						})
+
+						err := b.rxError(ErrorChecksum, rxRaw)
+						if err != nil {
+							return err
+						}
 					}
 
 					rxState = 0
@@ -132,88 +422,184 @@ func (b *PHY) Run() error {
 	}
 }
 
-// TXSendPacket encode and sends a packet to the remote device.
-func (b *PHY) TXSendPacket(addrSource uint8, addrDest uint8, payload []byte) error {
-	var sum uint16
+func (b *PHY) rxError(err error, raw []byte) error {
+	b.log().Debug("Discarded frame", "error", err, "raw", raw)
+	if b.Metrics != nil {
+		b.Metrics.OnError(err)
+	}
+	if b.RXHandleError != nil {
+		return b.RXHandleError(err, raw)
+	}
+	return nil
+}
+
+func (b *PHY) rxDeliver(t time.Time, checksum Checksum, addrSource uint8, addrDest uint8, payload []byte) error {
+	packet := Packet{
+		AddrSource: addrSource,
+		AddrDest:   addrDest,
+		Payload:    payload,
+		Checksum:   checksum,
+	}
+	if !b.rxHooks.run(&packet) {
+		return nil
+	}
+	addrSource, addrDest, payload = packet.AddrSource, packet.AddrDest, packet.Payload
+
+	if b.RXHandlePacket != nil {
+		err := b.RXHandlePacket(addrSource, addrDest, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.RXHandlePacketTimestamp != nil {
+		return b.RXHandlePacketTimestamp(t, addrSource, addrDest, payload)
+	}
 
+	return nil
+}
+
+/* The length field also counts the seed byte */
+const maxPayloadLength = 254
+
+/* Appends the encoded packet to out. frame is used as scratch space for the unescaped bytes. */
+func encodePacket(out []byte, frame []byte, addrSource uint8, addrDest uint8, seed uint8, scrambled bool, checksum Checksum, payload []byte) ([]byte, []byte) {
 	addByte := func(m byte) {
-		sum += uint16(m)
+		frame = append(frame, m)
 
 		if m == 0xAA {
-			b.txBuf = append(b.txBuf, 0xAA)
+			out = append(out, 0xAA)
 		}
-		b.txBuf = append(b.txBuf, m)
+		out = append(out, m)
 	}
 
-	b.txBuf = append(b.txBuf[:0], 0xAA)
+	out = append(out, 0xAA)
 	addByte(addrSource)
 	addByte(addrDest)
 	addByte(byte(len(payload) + 1))
+	addByte(seed)
 
-	var payloadScrambled []byte
-	if b.TXDisableScrambler {
-		addByte(120)
-		payloadScrambled = payload
-	} else {
-		addByte(b.txSeed)
+	payloadScrambled := payload
+	if scrambled {
 		payloadScrambled = make([]byte, len(payload))
-		scramble(b.txSeed, payloadScrambled, payload)
-		b.txSeed++
+		scramble(seed, payloadScrambled, payload)
 	}
 
 	for _, m := range payloadScrambled {
 		addByte(m)
 	}
 
-	finalSum := sum
+	finalSum := checksum.Compute(frame)
 	addByte(byte(finalSum))
 	addByte(byte(finalSum >> 8))
 
-	_, err := b.Port.Write(b.txBuf)
-	return err
+	return out, frame
 }
 
-// Close stops Run() and also closes the underlying io.Closer
-func (b *PHY) Close() error {
-	return b.Port.Close()
+// EncodePacket returns the bytes that would be sent on the line for a packet, using the
+// standard scrambler with seed 0 and the standard checksum.
+func EncodePacket(addrSource uint8, addrDest uint8, payload []byte) ([]byte, error) {
+	if len(payload) > maxPayloadLength {
+		return nil, ErrorOversize
+	}
+
+	out, _ := encodePacket(nil, nil, addrSource, addrDest, 0, true, ChecksumSum, payload)
+	return out, nil
 }
 
-// NewSerialSimple is a convenience function that sets the PHY up for a
-// standard serial port.
-func NewSerialSimple(portName string) (*PHY, error) {
-	options := serial.PortOptions{
-		PortName:      portName,
-		FlowControl:   false,
-		InterfaceRate: 9600,
+// TXSendPacket encode and sends a packet to the remote device. It is safe to call from
+// multiple goroutines.
+func (b *PHY) TXSendPacket(addrSource uint8, addrDest uint8, payload []byte) error {
+	packet := Packet{
+		AddrSource: addrSource,
+		AddrDest:   addrDest,
+		Payload:    payload,
+	}
+	if !b.txHooks.run(&packet) {
+		return nil
 	}
+	addrSource, addrDest, payload = packet.AddrSource, packet.AddrDest, packet.Payload
 
-	port, err := serial.Open(&options)
-	if err != nil {
-		return nil, err
+	if err := b.txRateLimit(addrDest); err != nil {
+		return err
 	}
 
-	phy := PHY{
-		Port:               port,
-		TXDisableScrambler: false,
-		TXSendBreak:        port.DoBreak,
+	b.txMutex.Lock()
+	defer b.txMutex.Unlock()
+
+	if len(payload) > maxPayloadLength {
+		return ErrorOversize
 	}
 
-	/* Test if sending break works */
-	err = phy.TXSendBreak(10 * time.Millisecond)
+	seed := uint8(120)
+	if !b.TXDisableScrambler {
+		seed = b.txNextSeed()
+	}
+
+	b.txBuf, b.txFrame = encodePacket(b.txBuf[:0], b.txFrame[:0], addrSource, addrDest, seed, !b.TXDisableScrambler, b.txChecksum(), payload)
+
+	if b.Capture != nil {
+		b.Capture.WriteFrame(time.Now(), DirectionTX, b.txBuf)
+	}
+
+	var err error
+	if b.TXCollisionDetect {
+		err = b.txWriteCollisionDetect(b.txBuf)
+	} else {
+		err = b.txWrite(b.txBuf)
+	}
 
-	/* Workaround for serial ports that cannot send a break */
 	if err != nil {
-		phy.TXSendBreak = func(d time.Duration) error {
-			port.SetInterfaceRate(300)
-			cd := 70 * time.Millisecond
-			for t := time.Duration(0); t < d; t += cd {
-				port.Write([]byte{0})
-				time.Sleep(cd)
-			}
-			port.SetInterfaceRate(options.InterfaceRate)
-			return nil
+		b.log().Warn("Transmit failed", "src", addrSource, "dst", addrDest, "error", err)
+	}
+
+	if b.Metrics != nil {
+		if err != nil {
+			b.Metrics.OnError(err)
+		} else {
+			b.Metrics.OnFrameTX(addrSource, addrDest, len(payload))
 		}
 	}
 
-	return &phy, nil
+	return err
+}
+
+func (b *PHY) txNextSeed() uint8 {
+	switch b.TXSeedMode {
+	case SeedFixed:
+		return b.TXSeed
+	case SeedRandom:
+		return uint8(rand.Intn(256))
+	}
+
+	seed := b.txSeed
+	b.txSeed++
+	return seed
+}
+
+func (b *PHY) txWrite(frame []byte) error {
+	if b.RXFilterEcho {
+		b.echo.transmitted(frame)
+	}
+
+	b.gap.wait(b.TXMinGap, b.TXGapAfterRX)
+
+	port, _ := b.port()
+	n, err := port.Write(frame)
+	b.gap.transmitted()
+	b.stats.update(func(stats *Stats) {
+		stats.BytesTX += uint64(n)
+		if err == nil {
+			stats.FramesTX++
+		}
+	})
+	return err
+}
+
+// Close stops Run() and also closes the underlying io.Closer
+func (b *PHY) Close() error {
+	b.closed.Close()
+
+	port, _ := b.port()
+	return port.Close()
 }
@@ -0,0 +1,384 @@
+package phy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+type pipePort struct {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+type receivedPacket struct {
+	addrSource uint8
+	addrDest   uint8
+	payload    []byte
+}
+
+type receivedError struct {
+	err error
+	raw []byte
+}
+
+/* received holds either a packet or an error, in the order the PHY reported them */
+type received struct {
+	packet *receivedPacket
+	err    *receivedError
+}
+
+/* harness feeds raw bytes into a PHY and collects what it reports */
+type harness struct {
+	phy      *phy.PHY
+	line     *io.PipeWriter
+	received chan (received)
+}
+
+func newHarness(t *testing.T, setup func(p *phy.PHY)) *harness {
+	r, w := io.Pipe()
+	t.Cleanup(func() {
+		w.Close()
+	})
+
+	h := runHarness(t, &phy.PHY{Port: pipePort{Reader: r, Writer: io.Discard, Closer: r}}, setup)
+	h.line = w
+	return h
+}
+
+/* newPairHarness returns a PHY on one end of a virtual line and a harness on the other end */
+func newPairHarness(t *testing.T, setupLocal func(p *phy.PHY), setupRemote func(p *phy.PHY)) (*phy.PHY, *harness) {
+	local, remote := newVirtualLine(t)
+	if setupLocal != nil {
+		setupLocal(local)
+	}
+	go local.Run()
+	t.Cleanup(func() {
+		local.Close()
+	})
+
+	return local, runHarness(t, &phy.PHY{Port: remote}, setupRemote)
+}
+
+func newVirtualLine(t *testing.T) (*phy.PHY, io.ReadWriteCloser) {
+	t.Helper()
+
+	local, remote, err := phy.NewVirtualPair()
+	if err != nil {
+		t.Skipf("Virtual line not available: %v", err)
+	}
+	t.Cleanup(func() {
+		remote.Close()
+	})
+
+	return local, remote
+}
+
+/* runHarness starts p and collects the packets and errors it reports */
+func runHarness(t *testing.T, p *phy.PHY, setup func(p *phy.PHY)) *harness {
+	h := &harness{
+		phy:      p,
+		received: make(chan (received), 16),
+	}
+	h.phy.RXHandlePacket = func(addrSource uint8, addrDest uint8, payload []byte) error {
+		h.received <- received{packet: &receivedPacket{addrSource, addrDest, append([]byte(nil), payload...)}}
+		return nil
+	}
+	h.phy.RXHandleError = func(err error, raw []byte) error {
+		h.received <- received{err: &receivedError{err, append([]byte(nil), raw...)}}
+		return nil
+	}
+	if setup != nil {
+		setup(h.phy)
+	}
+
+	go h.phy.Run()
+	t.Cleanup(func() {
+		h.phy.Close()
+	})
+
+	return h
+}
+
+func (h *harness) feed(t *testing.T, data ...[]byte) {
+	t.Helper()
+
+	for _, d := range data {
+		if _, err := h.line.Write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (h *harness) expectPacket(t *testing.T, addrSource uint8, addrDest uint8, payload []byte) {
+	t.Helper()
+
+	select {
+	case r := <-h.received:
+		if r.err != nil {
+			t.Fatalf("Received error %v (% x), expected a packet", r.err.err, r.err.raw)
+		}
+		p := r.packet
+		if p.addrSource != addrSource || p.addrDest != addrDest || !bytes.Equal(p.payload, payload) {
+			t.Fatalf("Received packet %d->%d % x, expected %d->%d % x", p.addrSource, p.addrDest, p.payload, addrSource, addrDest, payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No packet received")
+	}
+}
+
+func (h *harness) expectError(t *testing.T, expected error) receivedError {
+	t.Helper()
+
+	select {
+	case r := <-h.received:
+		if r.packet != nil {
+			t.Fatalf("Received packet % x, expected error %v", r.packet.payload, expected)
+		}
+		if !errors.Is(r.err.err, expected) {
+			t.Fatalf("Received error %v, expected %v", r.err.err, expected)
+		}
+		return *r.err
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Error %v not reported", expected)
+	}
+	return receivedError{}
+}
+
+func (h *harness) expectNothing(t *testing.T) {
+	t.Helper()
+
+	select {
+	case r := <-h.received:
+		if r.err != nil {
+			t.Fatalf("Unexpected error %v (% x)", r.err.err, r.err.raw)
+		}
+		t.Fatalf("Unexpected packet % x", r.packet.payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func mustEncode(t *testing.T, addrSource uint8, addrDest uint8, payload []byte) []byte {
+	t.Helper()
+
+	frame, err := phy.EncodePacket(addrSource, addrDest, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestParserValidFrame(t *testing.T) {
+	h := newHarness(t, nil)
+
+	h.feed(t, mustEncode(t, 5, 1, []byte{0x45, 0x00, 0x01, 0x02}))
+	h.expectPacket(t, 5, 1, []byte{0x45, 0x00, 0x01, 0x02})
+
+	/* Split over several reads */
+	frame := mustEncode(t, 6, 1, []byte{0x85, 'A', 'B'})
+	for i := range frame {
+		h.feed(t, frame[i:i+1])
+	}
+	h.expectPacket(t, 6, 1, []byte{0x85, 'A', 'B'})
+}
+
+func TestParserTruncatedFrame(t *testing.T) {
+	h := newHarness(t, nil)
+
+	truncated := mustEncode(t, 5, 1, []byte{0x45, 0x00, 0x01, 0x02})
+	truncated = truncated[:len(truncated)-3]
+
+	h.feed(t, truncated, mustEncode(t, 7, 1, []byte{0x43}))
+	e := h.expectError(t, phy.ErrorFrameInterrupted)
+	if !bytes.Equal(e.raw, truncated) {
+		t.Fatalf("Reported raw % x, expected % x", e.raw, truncated)
+	}
+	h.expectPacket(t, 7, 1, []byte{0x43})
+}
+
+func TestParserBadChecksum(t *testing.T) {
+	h := newHarness(t, nil)
+
+	bad := mustEncode(t, 5, 1, []byte{0x11, 0x22})
+	bad[len(bad)-2] ^= 0x01
+
+	h.feed(t, bad, mustEncode(t, 5, 1, []byte{0x33}))
+	e := h.expectError(t, phy.ErrorChecksum)
+	if !bytes.Equal(e.raw, bad) {
+		t.Fatalf("Reported raw % x, expected % x", e.raw, bad)
+	}
+	h.expectPacket(t, 5, 1, []byte{0x33})
+}
+
+func TestParserLength(t *testing.T) {
+	h := newHarness(t, func(p *phy.PHY) {
+		p.RXMaxPayloadLength = 4
+	})
+
+	/* A length of zero cannot even hold the seed */
+	h.feed(t, []byte{0xAA, 5, 1, 0}, mustEncode(t, 5, 1, []byte{0x01}))
+	h.expectError(t, phy.ErrorLength)
+	h.expectPacket(t, 5, 1, []byte{0x01})
+
+	h.feed(t, mustEncode(t, 5, 1, []byte{1, 2, 3, 4, 5}), mustEncode(t, 5, 1, []byte{1, 2, 3, 4}))
+	h.expectError(t, phy.ErrorOversize)
+	h.expectPacket(t, 5, 1, []byte{1, 2, 3, 4})
+}
+
+func TestParserEscapes(t *testing.T) {
+	var presence []byte
+	h := newHarness(t, func(p *phy.PHY) {
+		p.RXHandlePresense = func(m byte) error {
+			presence = append(presence, m)
+			return nil
+		}
+	})
+
+	/* An escaped 0xAA outside of a frame is a presence byte, not the start of a frame */
+	h.feed(t, []byte{0xAA, 0xAA})
+	h.expectNothing(t)
+
+	/* Payloads containing 0xAA are escaped by the encoder and must survive */
+	payload := []byte{0xAA, 0x00, 0xAA, 0xAA}
+	h.feed(t, mustEncode(t, 5, 0xAA, payload))
+	h.expectPacket(t, 5, 0xAA, payload)
+
+	/* An unescaped 0xAA in the middle of a frame starts a new one */
+	frame := mustEncode(t, 5, 1, []byte{0x01, 0x02, 0x03})
+	stray := append(append([]byte(nil), frame[:5]...), mustEncode(t, 9, 1, []byte{0x04})...)
+	h.feed(t, stray)
+	e := h.expectError(t, phy.ErrorFrameInterrupted)
+	if !bytes.Equal(e.raw, frame[:5]) {
+		t.Fatalf("Reported raw % x, expected % x", e.raw, frame[:5])
+	}
+	h.expectPacket(t, 9, 1, []byte{0x04})
+
+	if !bytes.Equal(presence, []byte{0xAA}) {
+		t.Fatalf("Presence bytes % x, expected aa", presence)
+	}
+}
+
+/* unescape removes the start marker and the escape bytes from an encoded frame */
+func unescape(t *testing.T, encoded []byte) []byte {
+	t.Helper()
+
+	if len(encoded) == 0 || encoded[0] != 0xAA {
+		t.Fatalf("Frame % x does not start with aa", encoded)
+	}
+
+	var frame []byte
+	for i := 1; i < len(encoded); i++ {
+		if encoded[i] == 0xAA {
+			if i+1 >= len(encoded) || encoded[i+1] != 0xAA {
+				t.Fatalf("Frame % x has an unescaped aa at %d", encoded, i)
+			}
+			i++
+		}
+		frame = append(frame, encoded[i])
+	}
+	return frame
+}
+
+func TestEncodePacket(t *testing.T) {
+	long := make([]byte, 254)
+	for i := range long {
+		long[i] = byte(i)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		addrSource uint8
+		addrDest   uint8
+		payload    []byte
+		err        error
+	}{
+		{name: "empty", addrSource: 1, addrDest: 5},
+		{name: "short", addrSource: 1, addrDest: 5, payload: []byte{0x84}},
+		{name: "escaped addresses", addrSource: 0xAA, addrDest: 0xAA, payload: []byte{0x01}},
+		{name: "escaped payload", addrSource: 1, addrDest: 5, payload: []byte{0xAA, 0xAA, 0x00, 0xAA}},
+		{name: "longest", addrSource: 1, addrDest: 5, payload: long},
+		{name: "too long", addrSource: 1, addrDest: 5, payload: make([]byte, 255), err: phy.ErrorOversize},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			encoded, err := phy.EncodePacket(tc.addrSource, tc.addrDest, tc.payload)
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) || encoded != nil {
+					t.Fatalf("Expected %v, got % x, %v", tc.err, encoded, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			frame := unescape(t, encoded)
+			if len(frame) != len(tc.payload)+6 {
+				t.Fatalf("Unescaped frame has %d bytes, expected %d", len(frame), len(tc.payload)+6)
+			}
+			if frame[0] != tc.addrSource || frame[1] != tc.addrDest {
+				t.Fatalf("Frame addresses are %d->%d", frame[0], frame[1])
+			}
+
+			/* The length counts the seed, which is always 0 */
+			if frame[2] != byte(len(tc.payload)+1) || frame[3] != 0 {
+				t.Fatalf("Frame has length %d and seed %d", frame[2], frame[3])
+			}
+
+			body := frame[:len(frame)-2]
+			sum := uint16(frame[len(frame)-2]) | uint16(frame[len(frame)-1])<<8
+			if sum != phy.ChecksumSum.Compute(body) {
+				t.Fatalf("Frame has checksum %04x, expected %04x", sum, phy.ChecksumSum.Compute(body))
+			}
+
+			/* The address 0xAA cannot be told apart from an escaped presence byte by the
+			 * decoder, so only the encoding is checked for those */
+			if tc.addrSource == 0xAA {
+				return
+			}
+
+			h := newHarness(t, nil)
+			h.feed(t, encoded)
+			h.expectPacket(t, tc.addrSource, tc.addrDest, tc.payload)
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	local, remote := newVirtualLine(t)
+	h := runHarness(t, local, func(p *phy.PHY) {
+		p.RXIdleTimeout = 50 * time.Millisecond
+	})
+
+	/* A pause shorter than the timeout does not split a frame */
+	frame := mustEncode(t, 5, 1, []byte{0x45, 0x01, 0x02})
+	remote.Write(frame[:4])
+	time.Sleep(10 * time.Millisecond)
+	remote.Write(frame[4:])
+	h.expectPacket(t, 5, 1, []byte{0x45, 0x01, 0x02})
+
+	/* The start of the next frame arrives after the timeout, the partial frame is discarded */
+	remote.Write(frame[:4])
+	time.Sleep(150 * time.Millisecond)
+	remote.Write(mustEncode(t, 6, 1, []byte{0x03}))
+	e := h.expectError(t, phy.ErrorFrameTimeout)
+	if !bytes.Equal(e.raw, frame[:4]) {
+		t.Fatalf("Reported raw % x, expected % x", e.raw, frame[:4])
+	}
+	h.expectPacket(t, 6, 1, []byte{0x03})
+
+	/* The rest of a frame that timed out is not taken as the start of a new one */
+	remote.Write(frame[:4])
+	time.Sleep(150 * time.Millisecond)
+	remote.Write(frame[4:])
+	h.expectError(t, phy.ErrorFrameTimeout)
+	h.expectNothing(t)
+
+	if partial := local.Stats().PartialFrames; partial != 2 {
+		t.Fatalf("Counted %d partial frames, expected 2", partial)
+	}
+}
@@ -0,0 +1,42 @@
+package phy
+
+import (
+	"io"
+	"time"
+)
+
+func (b *PHY) port() (io.ReadWriteCloser, uint64) {
+	b.portMutex.Lock()
+	defer b.portMutex.Unlock()
+
+	return b.Port, b.portGeneration
+}
+
+func (b *PHY) portChanged(generation uint64) bool {
+	b.portMutex.Lock()
+	defer b.portMutex.Unlock()
+
+	return b.portGeneration != generation
+}
+
+// SetPort replaces the underlying port while Run() keeps going. The previous port is closed.
+// If the new port has a DoBreak(time.Duration) error method, like serial ports do, it is used
+// as TXSendBreak. Otherwise TXSendBreak is left unchanged.
+func (b *PHY) SetPort(port io.ReadWriteCloser) error {
+	/* Do not swap in the middle of a transmission */
+	b.txMutex.Lock()
+	if breaker, ok := port.(interface{ DoBreak(time.Duration) error }); ok {
+		b.TXSendBreak = breaker.DoBreak
+	}
+
+	b.portMutex.Lock()
+	old := b.Port
+	b.Port = port
+	b.portGeneration++
+	b.portMutex.Unlock()
+	b.txMutex.Unlock()
+
+	b.log().Info("Port replaced")
+
+	return old.Close()
+}
@@ -0,0 +1,79 @@
+package phy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrorRateLimited is returned when a packet exceeds the rate limit and TXRateReject is set.
+	ErrorRateLimited = errors.New("Packet rate limit exceeded")
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	buckets map[uint8]*tokenBucket
+}
+
+/* Takes a token for the destination. If none is available it returns how long to wait for one. */
+func (r *rateLimiter) take(addrDest uint8, rate float64, burst int) time.Duration {
+	r.Lock()
+	defer r.Unlock()
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	if r.buckets == nil {
+		r.buckets = make(map[uint8]*tokenBucket)
+	}
+
+	now := time.Now()
+	bucket, ok := r.buckets[addrDest]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(burst), last: now}
+		r.buckets[addrDest] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+	if bucket.tokens > float64(burst) {
+		bucket.tokens = float64(burst)
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+func (b *PHY) txRateLimit(addrDest uint8) error {
+	if b.TXRateLimit <= 0 {
+		return nil
+	}
+
+	for {
+		wait := b.rateLimiter.take(addrDest, b.TXRateLimit, b.TXRateBurst)
+		if wait == 0 {
+			return nil
+		}
+
+		if b.TXRateReject {
+			return ErrorRateLimited
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-b.closed.Chan():
+			return ErrorPortClosed
+		}
+	}
+}
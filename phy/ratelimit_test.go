@@ -0,0 +1,83 @@
+package phy_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func TestRateLimitWait(t *testing.T) {
+	local, h := newPairHarness(t, func(p *phy.PHY) {
+		p.TXRateLimit = 20
+		p.TXRateBurst = 2
+	}, nil)
+
+	/* The burst is sent immediately, the next packet waits for a token */
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := local.TXSendPacket(1, 5, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("Three packets were sent in %v, expected the third one to wait 50ms", elapsed)
+	}
+
+	/* Other destinations have their own bucket */
+	start = time.Now()
+	if err := local.TXSendPacket(1, 6, []byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Fatalf("Packet to another destination waited %v", elapsed)
+	}
+
+	for i := 0; i < 3; i++ {
+		h.expectPacket(t, 1, 5, []byte{byte(i)})
+	}
+	h.expectPacket(t, 1, 6, []byte{3})
+}
+
+func TestRateLimitReject(t *testing.T) {
+	local, h := newPairHarness(t, func(p *phy.PHY) {
+		p.TXRateLimit = 1
+		p.TXRateReject = true
+	}, nil)
+
+	if err := local.TXSendPacket(1, 5, []byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.TXSendPacket(1, 5, []byte{1}); !errors.Is(err, phy.ErrorRateLimited) {
+		t.Fatalf("Expected ErrorRateLimited, got %v", err)
+	}
+
+	/* The rejected packet never reaches the line */
+	h.expectPacket(t, 1, 5, []byte{0})
+	h.expectNothing(t)
+}
+
+func TestRateLimitClose(t *testing.T) {
+	local, _ := newPairHarness(t, func(p *phy.PHY) {
+		p.TXRateLimit = 0.1
+	}, nil)
+
+	local.TXSendPacket(1, 5, []byte{0})
+
+	result := make(chan (error), 1)
+	go func() {
+		result <- local.TXSendPacket(1, 5, []byte{1})
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	local.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, phy.ErrorPortClosed) {
+			t.Fatalf("Expected ErrorPortClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("TXSendPacket kept waiting after Close")
+	}
+}
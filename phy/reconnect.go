@@ -0,0 +1,164 @@
+package phy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrorPortClosed is returned when the port has been closed.
+	ErrorPortClosed = errors.New("Port has been closed")
+)
+
+// ReconnectEvent describes a change in the state of a reconnecting serial port.
+type ReconnectEvent int
+
+const (
+	// ReconnectEventLost is reported when the port failed.
+	ReconnectEventLost ReconnectEvent = 0
+	// ReconnectEventRestored is reported when the port was opened again.
+	ReconnectEventRestored ReconnectEvent = 1
+	// ReconnectEventAbandoned is reported when the maximum number of attempts was reached.
+	ReconnectEventAbandoned ReconnectEvent = 2
+)
+
+func (e ReconnectEvent) String() string {
+	switch e {
+	case ReconnectEventLost:
+		return "lost"
+	case ReconnectEventRestored:
+		return "restored"
+	case ReconnectEventAbandoned:
+		return "abandoned"
+	}
+	return fmt.Sprintf("ReconnectEvent(%d)", int(e))
+}
+
+type serialReconnectPort struct {
+	sync.Mutex
+
+	options SerialOptions
+	byID    string
+
+	port      io.ReadWriteCloser
+	portBreak func(d time.Duration) error
+	closed    bool
+	closeChan chan (struct{})
+}
+
+func newSerialReconnectPort(options *SerialOptions) (*serialReconnectPort, error) {
+	port, sendBreak, err := serialOpen(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serialReconnectPort{
+		options:   *options,
+		byID:      serialFindByID(options.PortName),
+		port:      port,
+		portBreak: sendBreak,
+		closeChan: make(chan (struct{})),
+	}, nil
+}
+
+func (r *serialReconnectPort) event(event ReconnectEvent, portName string, err error) {
+	if r.options.OnReconnectEvent != nil {
+		r.options.OnReconnectEvent(event, portName, err)
+	}
+}
+
+func (r *serialReconnectPort) current() io.ReadWriteCloser {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.port
+}
+
+func (r *serialReconnectPort) reopen(cause error) error {
+	r.current().Close()
+	r.event(ReconnectEventLost, r.options.PortName, cause)
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(r.options.ReconnectInterval):
+		case <-r.closeChan:
+			return ErrorPortClosed
+		}
+
+		opts := r.options
+		if r.byID != "" {
+			opts.PortName = serialResolveByID(r.byID, opts.PortName)
+		}
+
+		port, sendBreak, err := serialOpen(&opts)
+		if err == nil {
+			r.Lock()
+			if r.closed {
+				r.Unlock()
+				port.Close()
+				return ErrorPortClosed
+			}
+			r.port = port
+			r.portBreak = sendBreak
+			r.Unlock()
+
+			r.event(ReconnectEventRestored, opts.PortName, nil)
+			return nil
+		}
+
+		if r.options.ReconnectAttempts > 0 && attempt >= r.options.ReconnectAttempts {
+			r.event(ReconnectEventAbandoned, opts.PortName, err)
+			return err
+		}
+	}
+}
+
+func (r *serialReconnectPort) Read(p []byte) (int, error) {
+	for {
+		n, err := r.current().Read(p)
+		if err == nil || n > 0 {
+			return n, err
+		}
+
+		r.Lock()
+		closed := r.closed
+		r.Unlock()
+
+		if closed {
+			return 0, err
+		}
+
+		if err := r.reopen(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (r *serialReconnectPort) Write(p []byte) (int, error) {
+	return r.current().Write(p)
+}
+
+func (r *serialReconnectPort) sendBreak(d time.Duration) error {
+	r.Lock()
+	sendBreak := r.portBreak
+	r.Unlock()
+
+	return sendBreak(d)
+}
+
+func (r *serialReconnectPort) Close() error {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.closeChan)
+	port := r.port
+	r.Unlock()
+
+	return port.Close()
+}
@@ -0,0 +1,94 @@
+package phy
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrorReplayClosed is returned by the replay port after it has been closed.
+	ErrorReplayClosed = errors.New("Replay has been closed")
+)
+
+type replayPort struct {
+	capture *CaptureReader
+	speed   float64
+
+	closeChan chan (struct{})
+	closeOnce sync.Once
+
+	pending   []byte
+	lastFrame time.Time
+}
+
+func (r *replayPort) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		frame, err := r.capture.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+
+		/* Only received frames are fed back into the parser */
+		if frame.Direction != DirectionRX {
+			continue
+		}
+
+		if r.speed > 0 && !r.lastFrame.IsZero() {
+			delay := time.Duration(float64(frame.Time.Sub(r.lastFrame)) / r.speed)
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-r.closeChan:
+					return 0, ErrorReplayClosed
+				}
+			}
+		}
+		r.lastFrame = frame.Time
+
+		r.pending = frame.Frame
+	}
+
+	select {
+	case <-r.closeChan:
+		return 0, ErrorReplayClosed
+	default:
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *replayPort) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (r *replayPort) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+	})
+	return nil
+}
+
+// NewReplay creates a PHY that feeds the received frames stored in a capture file into
+// RXHandlePacket. Transmitted packets are discarded. When speed is 1 the original timing
+// is reproduced, higher values accelerate the replay and 0 replays as fast as possible.
+// Run() returns io.EOF when the end of the capture is reached.
+func NewReplay(reader io.Reader, speed float64) (*PHY, error) {
+	capture, err := NewCaptureReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	port := &replayPort{
+		capture:   capture,
+		speed:     speed,
+		closeChan: make(chan (struct{})),
+	}
+
+	return &PHY{
+		Port: port,
+	}, nil
+}
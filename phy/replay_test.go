@@ -0,0 +1,105 @@
+package phy_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	w, err := phy.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteFrame(start, phy.DirectionRX, mustEncode(t, 5, 1, []byte{0x45, 0x01}))
+	w.WriteFrame(start.Add(10*time.Millisecond), phy.DirectionTX, mustEncode(t, 1, 5, []byte{0x44}))
+	w.WriteFrame(start.Add(100*time.Millisecond), phy.DirectionRX, mustEncode(t, 6, 1, []byte{0x85, 0xAA}))
+
+	p, err := phy.NewReplay(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []receivedPacket
+	var times []time.Time
+	p.RXHandlePacket = func(addrSource uint8, addrDest uint8, payload []byte) error {
+		packets = append(packets, receivedPacket{addrSource, addrDest, append([]byte(nil), payload...)})
+		times = append(times, time.Now())
+		return nil
+	}
+
+	if err := p.Run(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected io.EOF at the end of the replay, got %v", err)
+	}
+
+	/* The transmitted frame is not fed back */
+	expected := []receivedPacket{
+		{5, 1, []byte{0x45, 0x01}},
+		{6, 1, []byte{0x85, 0xAA}},
+	}
+	if len(packets) != len(expected) {
+		t.Fatalf("Replayed %d packets, expected %d", len(packets), len(expected))
+	}
+	for i, e := range expected {
+		if packets[i].addrSource != e.addrSource || packets[i].addrDest != e.addrDest || !bytes.Equal(packets[i].payload, e.payload) {
+			t.Fatalf("Packet %d is %d->%d % x, expected %d->%d % x", i, packets[i].addrSource, packets[i].addrDest, packets[i].payload, e.addrSource, e.addrDest, e.payload)
+		}
+	}
+
+	/* The original timing is reproduced at speed 1 */
+	if gap := times[1].Sub(times[0]); gap < 80*time.Millisecond {
+		t.Fatalf("Packets were replayed %v apart, expected about 100ms", gap)
+	}
+}
+
+func TestReplayClose(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	w, err := phy.NewCaptureWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteFrame(start, phy.DirectionRX, mustEncode(t, 5, 1, []byte{0x45}))
+	w.WriteFrame(start.Add(time.Hour), phy.DirectionRX, mustEncode(t, 5, 1, []byte{0x45}))
+
+	p, err := phy.NewReplay(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan (struct{}), 2)
+	p.RXHandlePacket = func(addrSource uint8, addrDest uint8, payload []byte) error {
+		received <- struct{}{}
+		return nil
+	}
+
+	result := make(chan (error), 1)
+	go func() {
+		result <- p.Run()
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("First packet was not replayed")
+	}
+
+	/* Closing interrupts the wait for the second frame */
+	p.Close()
+	select {
+	case err := <-result:
+		if errors.Is(err, io.EOF) {
+			t.Fatalf("Replay reached the end instead of being closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after Close")
+	}
+}
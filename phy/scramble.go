@@ -0,0 +1,97 @@
+package phy
+
+import "sync"
+
+// ScrambleVariant describes how a device scrambles its payloads.
+type ScrambleVariant struct {
+	// Name is a short description of the variant.
+	Name string
+
+	// Offset is the constant that is added to the seed to form the initial key.
+	Offset uint8
+
+	// Plain is set for devices that do not scramble at all.
+	Plain bool
+}
+
+var (
+	// ScrambleStandard is the scrambling used by genuine BattGO devices.
+	ScrambleStandard = ScrambleVariant{Name: "standard", Offset: 136}
+
+	// ScramblePlain is used by devices that send plaintext payloads.
+	ScramblePlain = ScrambleVariant{Name: "plain", Plain: true}
+)
+
+func (v *ScrambleVariant) apply(seed uint8, out []byte, in []byte) {
+	if v.Plain {
+		copy(out, in)
+		return
+	}
+	scrambleOffset(seed, v.Offset, out, in)
+}
+
+type scrambleDetector struct {
+	sync.Mutex
+
+	variants map[uint8]ScrambleVariant
+	buf      []byte
+}
+
+func (s *scrambleDetector) get(addr uint8) (ScrambleVariant, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	v, ok := s.variants[addr]
+	return v, ok
+}
+
+func (s *scrambleDetector) set(addr uint8, v ScrambleVariant) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.variants == nil {
+		s.variants = make(map[uint8]ScrambleVariant)
+	}
+	s.variants[addr] = v
+}
+
+/* Descrambles data in place. When autodetection is enabled, the variant known for the source
+ * address is tried first, followed by all candidates until RXScrambleValidate accepts one. */
+func (b *PHY) rxDescramble(addrSource uint8, seed uint8, data []byte) {
+	if b.RXScrambleValidate == nil {
+		scramble(seed, data, data)
+		return
+	}
+
+	candidates := b.RXScrambleVariants
+	if len(candidates) == 0 {
+		candidates = []ScrambleVariant{ScrambleStandard, ScramblePlain}
+	}
+
+	known, ok := b.scrambler.get(addrSource)
+	if ok {
+		candidates = append([]ScrambleVariant{known}, candidates...)
+	}
+
+	out := append(b.scrambler.buf[:0], data...)
+	b.scrambler.buf = out
+
+	for _, m := range candidates {
+		m.apply(seed, out, data)
+		if b.RXScrambleValidate(addrSource, out) {
+			if !ok || known != m {
+				b.scrambler.set(addrSource, m)
+			}
+			copy(data, out)
+			return
+		}
+	}
+
+	/* Nothing matched, fall back to the standard scrambler */
+	scramble(seed, data, data)
+}
+
+// ScrambleVariantOf returns the scramble variant that was detected for a source address.
+func (b *PHY) ScrambleVariantOf(addr uint8) (ScrambleVariant, bool) {
+	return b.scrambler.get(addr)
+}
@@ -0,0 +1,54 @@
+package phy_test
+
+import (
+	"testing"
+
+	"github.com/BertoldVdb/go-battgo/phy"
+)
+
+/* plainFrame encodes a frame without scrambling the payload. TXDisableScrambler cannot be used
+ * for this, it picks a seed for which the standard scrambler does not change the payload. */
+func plainFrame(addrSource uint8, addrDest uint8, seed uint8, payload []byte) []byte {
+	frame := append([]byte{addrSource, addrDest, byte(len(payload) + 1), seed}, payload...)
+	sum := phy.ChecksumSum.Compute(frame)
+	frame = append(frame, byte(sum), byte(sum>>8))
+
+	out := []byte{0xAA}
+	for _, m := range frame {
+		if m == 0xAA {
+			out = append(out, 0xAA)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestScrambleAutodetect(t *testing.T) {
+	h := newHarness(t, func(p *phy.PHY) {
+		p.RXScrambleValidate = func(addrSource uint8, payload []byte) bool {
+			return len(payload) > 0 && payload[0] == 0x45
+		}
+	})
+
+	/* A genuine device scrambles its payloads */
+	h.feed(t, mustEncode(t, 5, 1, []byte{0x45, 0x01, 0x02}))
+	h.expectPacket(t, 5, 1, []byte{0x45, 0x01, 0x02})
+
+	/* Another device sends plaintext */
+	h.feed(t, plainFrame(6, 1, 17, []byte{0x45, 0x03, 0x04}))
+	h.expectPacket(t, 6, 1, []byte{0x45, 0x03, 0x04})
+
+	if v, ok := h.phy.ScrambleVariantOf(5); !ok || v != phy.ScrambleStandard {
+		t.Fatalf("Device 5 uses %v, expected %v", v, phy.ScrambleStandard)
+	}
+	if v, ok := h.phy.ScrambleVariantOf(6); !ok || v != phy.ScramblePlain {
+		t.Fatalf("Device 6 uses %v, expected %v", v, phy.ScramblePlain)
+	}
+	if _, ok := h.phy.ScrambleVariantOf(7); ok {
+		t.Fatal("Device 7 has a variant without sending anything")
+	}
+
+	/* The learned variant is used for the next packet */
+	h.feed(t, plainFrame(6, 1, 99, []byte{0x45, 0x05}))
+	h.expectPacket(t, 6, 1, []byte{0x45, 0x05})
+}
@@ -0,0 +1,335 @@
+package phy
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/BertoldVdb/go-misc/serial"
+)
+
+var (
+	// ErrorSerialOption is returned when a serial option has an invalid value.
+	ErrorSerialOption = errors.New("Invalid serial port option")
+)
+
+// Parity selects the parity mode of the serial port.
+type Parity int
+
+const (
+	// ParityNone disables the parity bit.
+	ParityNone Parity = 0
+	// ParityOdd enables odd parity.
+	ParityOdd Parity = 1
+	// ParityEven enables even parity.
+	ParityEven Parity = 2
+)
+
+// BreakStrategy selects how the serial PHY generates a break on the line.
+type BreakStrategy int
+
+const (
+	// BreakAuto uses the native break and falls back to BreakBaudDrop if the port does not support it.
+	BreakAuto BreakStrategy = 0
+	// BreakNative uses the break function of the serial driver.
+	BreakNative BreakStrategy = 1
+	// BreakBaudDrop lowers the baud rate and sends zero bytes to hold the line low.
+	BreakBaudDrop BreakStrategy = 2
+	// BreakRTS asserts RTS for the duration of the break. RTS must be wired to pull the signal line low.
+	BreakRTS BreakStrategy = 3
+	// BreakDTR asserts DTR for the duration of the break. DTR must be wired to pull the signal line low.
+	BreakDTR BreakStrategy = 4
+	// BreakGPIO drives a GPIO line low for the duration of the break. The line is only requested
+	// while the break is sent. It must not be owned by the UART, as releasing it does not restore
+	// the pin function. Only available on Linux.
+	BreakGPIO BreakStrategy = 5
+)
+
+// SerialOptions contains the parameters used by NewSerial. Zero values select the
+// settings used by BattGO devices.
+type SerialOptions struct {
+	// PortName is the path of the serial device.
+	PortName string
+
+	// BaudRate is the line rate in bits per second. Defaults to 9600.
+	BaudRate uint32
+
+	// Parity selects the parity mode. Defaults to no parity.
+	Parity Parity
+
+	// StopBits is the number of stop bits, either 1 or 2. Defaults to 1.
+	StopBits int
+
+	// ReadTimeout is the time after which the driver returns from a read without data.
+	// It determines how quickly a blocked Run() notices Close(). It is rounded to units of 100ms
+	// and limited to 25.5s. Defaults to 1s.
+	ReadTimeout time.Duration
+
+	// FlowControl enables RTS/CTS hardware flow control.
+	FlowControl bool
+
+	// LowLatency asks the driver to deliver received data immediately instead of buffering it.
+	// For FTDI adapters the latency timer is also reduced to 1ms. Drivers that do not support
+	// this are used with their default settings.
+	LowLatency bool
+
+	// Break selects how breaks are generated. Defaults to BreakAuto.
+	Break BreakStrategy
+
+	// BreakGPIOChip is the GPIO character device used by BreakGPIO, for example /dev/gpiochip0.
+	BreakGPIOChip string
+
+	// BreakGPIOLine is the offset of the line on BreakGPIOChip used by BreakGPIO.
+	BreakGPIOLine int
+
+	// RS485 enables driver-enable control for half-duplex RS-485 adapters. RTS is asserted
+	// before each transmission and released once the last byte has left the port.
+	RS485 bool
+
+	// RS485DelayBeforeTX is the time between asserting RTS and starting the transmission.
+	RS485DelayBeforeTX time.Duration
+
+	// RS485DelayAfterTX is the time between the end of the transmission and releasing RTS.
+	RS485DelayAfterTX time.Duration
+
+	// Reconnect enables reopening the port when it fails, for example because a USB adapter was
+	// unplugged. Where possible the adapter is found again by its USB serial number.
+	Reconnect bool
+
+	// ReconnectInterval is the time between reconnection attempts. Defaults to 1s.
+	ReconnectInterval time.Duration
+
+	// ReconnectAttempts is the number of failed attempts after which Run() returns. Zero means
+	// the port is retried forever.
+	ReconnectAttempts int
+
+	// OnReconnectEvent is an optional callback that is called when the port is lost, reopened or
+	// when reconnecting is abandoned.
+	OnReconnectEvent func(event ReconnectEvent, portName string, err error)
+}
+
+func (o *SerialOptions) setDefaults() {
+	if o.BaudRate == 0 {
+		o.BaudRate = 9600
+	}
+	if o.StopBits == 0 {
+		o.StopBits = 1
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = time.Second
+	}
+	if o.ReconnectInterval == 0 {
+		o.ReconnectInterval = time.Second
+	}
+}
+
+func serialOpen(opts *SerialOptions) (io.ReadWriteCloser, func(d time.Duration) error, error) {
+	portOptions := serial.PortOptions{
+		PortName:      opts.PortName,
+		FlowControl:   opts.FlowControl,
+		InterfaceRate: opts.BaudRate,
+	}
+
+	port, err := serial.Open(&portOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = serialSetLineOptions(opts)
+	if err != nil {
+		port.Close()
+		return nil, nil, err
+	}
+
+	if opts.LowLatency {
+		/* This is only an optimization, errors are not fatal */
+		serialSetLowLatency(opts.PortName)
+	}
+
+	sendBreak, err := serialBreakFunc(port, opts)
+	if err != nil {
+		port.Close()
+		return nil, nil, err
+	}
+
+	if opts.RS485 {
+		if opts.Break == BreakRTS {
+			port.Close()
+			return nil, nil, ErrorSerialOption
+		}
+
+		rs485, err := newRS485Port(port, opts)
+		if err != nil {
+			port.Close()
+			return nil, nil, err
+		}
+
+		return rs485, rs485.wrapBreak(sendBreak), nil
+	}
+
+	return port, sendBreak, nil
+}
+
+// NewSerial sets the PHY up for a serial port using the given options.
+func NewSerial(options *SerialOptions) (*PHY, error) {
+	opts := *options
+	opts.setDefaults()
+
+	if opts.Reconnect {
+		port, err := newSerialReconnectPort(&opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PHY{
+			Port:               port,
+			TXDisableScrambler: false,
+			TXSendBreak:        port.sendBreak,
+		}, nil
+	}
+
+	port, sendBreak, err := serialOpen(&opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PHY{
+		Port:               port,
+		TXDisableScrambler: false,
+		TXSendBreak:        sendBreak,
+	}, nil
+}
+
+type rs485Port struct {
+	serial.Port
+
+	control     *os.File
+	delayBefore time.Duration
+	delayAfter  time.Duration
+}
+
+func newRS485Port(port serial.Port, options *SerialOptions) (*rs485Port, error) {
+	control, err := serialOpenControl(options.PortName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &rs485Port{
+		Port:        port,
+		control:     control,
+		delayBefore: options.RS485DelayBeforeTX,
+		delayAfter:  options.RS485DelayAfterTX,
+	}
+
+	if err := port.SetRTS(false); err != nil {
+		control.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *rs485Port) driverEnable() error {
+	if err := p.SetRTS(true); err != nil {
+		return err
+	}
+	time.Sleep(p.delayBefore)
+	return nil
+}
+
+func (p *rs485Port) driverDisable() error {
+	err := serialDrain(p.control)
+	time.Sleep(p.delayAfter)
+	if errRTS := p.SetRTS(false); err == nil {
+		err = errRTS
+	}
+	return err
+}
+
+func (p *rs485Port) Write(buf []byte) (int, error) {
+	if err := p.driverEnable(); err != nil {
+		return 0, err
+	}
+
+	n, err := p.Port.Write(buf)
+	if errDisable := p.driverDisable(); err == nil {
+		err = errDisable
+	}
+	return n, err
+}
+
+func (p *rs485Port) wrapBreak(sendBreak func(d time.Duration) error) func(d time.Duration) error {
+	return func(d time.Duration) error {
+		if err := p.driverEnable(); err != nil {
+			return err
+		}
+
+		err := sendBreak(d)
+		if errDisable := p.driverDisable(); err == nil {
+			err = errDisable
+		}
+		return err
+	}
+}
+
+func (p *rs485Port) Close() error {
+	p.control.Close()
+	return p.Port.Close()
+}
+
+func serialBreakBaudDrop(port serial.Port, baudRate uint32) func(d time.Duration) error {
+	return func(d time.Duration) error {
+		port.SetInterfaceRate(300)
+		cd := 70 * time.Millisecond
+		for t := time.Duration(0); t < d; t += cd {
+			port.Write([]byte{0})
+			time.Sleep(cd)
+		}
+		port.SetInterfaceRate(baudRate)
+		return nil
+	}
+}
+
+func serialBreakPin(setPin func(bool) error) func(d time.Duration) error {
+	return func(d time.Duration) error {
+		if err := setPin(true); err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return setPin(false)
+	}
+}
+
+func serialBreakFunc(port serial.Port, options *SerialOptions) (func(d time.Duration) error, error) {
+	switch options.Break {
+	case BreakAuto:
+		/* Test if sending break works */
+		if port.DoBreak(10*time.Millisecond) == nil {
+			return port.DoBreak, nil
+		}
+
+		/* Workaround for serial ports that cannot send a break */
+		return serialBreakBaudDrop(port, options.BaudRate), nil
+	case BreakNative:
+		return port.DoBreak, nil
+	case BreakBaudDrop:
+		return serialBreakBaudDrop(port, options.BaudRate), nil
+	case BreakRTS:
+		return serialBreakPin(port.SetRTS), port.SetRTS(false)
+	case BreakDTR:
+		return serialBreakPin(port.SetDTR), port.SetDTR(false)
+	case BreakGPIO:
+		return serialBreakGPIO(options.BreakGPIOChip, options.BreakGPIOLine)
+	}
+
+	return nil, ErrorSerialOption
+}
+
+// NewSerialSimple is a convenience function that sets the PHY up for a
+// standard serial port.
+func NewSerialSimple(portName string) (*PHY, error) {
+	return NewSerial(&SerialOptions{
+		PortName: portName,
+	})
+}
@@ -0,0 +1,194 @@
+package phy
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+/* Opens a second descriptor of the port, for changing settings the serial library does not expose */
+func serialOpenControl(portName string) (*os.File, error) {
+	return os.OpenFile(portName, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0600)
+}
+
+/* Waits until all written data has been transmitted (tcdrain) */
+func serialDrain(file *os.File) error {
+	return unix.IoctlSetInt(int(file.Fd()), unix.TCSBRK, 1)
+}
+
+/* The termios settings belong to the tty, so they can be changed through a second descriptor */
+func serialSetLineOptions(options *SerialOptions) error {
+	if options.StopBits != 1 && options.StopBits != 2 {
+		return ErrorSerialOption
+	}
+
+	file, err := serialOpenControl(options.PortName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	termios, err := unix.IoctlGetTermios(int(file.Fd()), unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+
+	termios.Cflag &= ^uint32(unix.PARENB | unix.PARODD | unix.CSTOPB)
+	switch options.Parity {
+	case ParityNone:
+	case ParityOdd:
+		termios.Cflag |= unix.PARENB | unix.PARODD
+	case ParityEven:
+		termios.Cflag |= unix.PARENB
+	default:
+		return ErrorSerialOption
+	}
+
+	if options.StopBits == 2 {
+		termios.Cflag |= unix.CSTOPB
+	}
+
+	vtime := options.ReadTimeout / (100 * time.Millisecond)
+	if vtime < 1 {
+		vtime = 1
+	} else if vtime > 255 {
+		vtime = 255
+	}
+	termios.Cc[unix.VTIME] = uint8(vtime)
+
+	return unix.IoctlSetTermios(int(file.Fd()), unix.TCSETS2, termios)
+}
+
+func serialCandidatePorts() ([]string, error) {
+	var result []string
+
+	for _, pattern := range []string{"/dev/ttyUSB*", "/dev/ttyACM*", "/dev/ttyAMA*"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matches...)
+	}
+
+	return result, nil
+}
+
+/* Find the /dev/serial/by-id link of a port, which contains the USB serial number */
+func serialFindByID(portName string) string {
+	target, err := filepath.EvalSymlinks(portName)
+	if err != nil {
+		return ""
+	}
+
+	links, _ := filepath.Glob("/dev/serial/by-id/*")
+	for _, m := range links {
+		if resolved, err := filepath.EvalSymlinks(m); err == nil && resolved == target {
+			return m
+		}
+	}
+
+	return ""
+}
+
+func serialResolveByID(byID string, fallback string) string {
+	resolved, err := filepath.EvalSymlinks(byID)
+	if err != nil {
+		return fallback
+	}
+	return resolved
+}
+
+/* Returns a break function if the file is a terminal */
+func serialFileBreak(file *os.File) func(d time.Duration) error {
+	if _, err := unix.IoctlGetTermios(int(file.Fd()), unix.TCGETS2); err != nil {
+		return nil
+	}
+
+	/* The break can be sent while the port is being closed, so the descriptor is only used
+	 * through SyscallConn, which fails instead of racing with Close */
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	ioctl := func(req uint, name string) error {
+		var errIoctl error
+		if err := conn.Control(func(fd uintptr) {
+			errIoctl = unix.IoctlSetInt(int(fd), req, 0)
+		}); err != nil {
+			return err
+		}
+		if errIoctl != nil {
+			return os.NewSyscallError(name, errIoctl)
+		}
+		return nil
+	}
+
+	return func(d time.Duration) error {
+		if err := ioctl(unix.TIOCSBRK, "TIOCSBRK"); err != nil {
+			return err
+		}
+
+		time.Sleep(d)
+
+		return ioctl(unix.TIOCCBRK, "TIOCCBRK")
+	}
+}
+
+/* struct serial_struct from linux/serial.h */
+type serialStruct struct {
+	typ           int32
+	line          int32
+	port          uint32
+	irq           int32
+	flags         int32
+	xmitFifoSize  int32
+	customDivisor int32
+	baudBase      int32
+	closeDelay    uint16
+	ioType        uint8
+	reservedChar  uint8
+	hub6          int32
+	closingWait   uint16
+	closingWait2  uint16
+	iomemBase     uintptr
+	iomemRegShift uint16
+	portHigh      uint32
+	iomapBase     uintptr
+}
+
+const serialAsyncLowLatency = 1 << 13
+
+func serialSetLowLatency(portName string) error {
+	/* FTDI adapters buffer data for the duration of their latency timer */
+	if target, err := filepath.EvalSymlinks(portName); err == nil {
+		timer := filepath.Join("/sys/bus/usb-serial/devices", filepath.Base(target), "latency_timer")
+		if _, err := os.Stat(timer); err == nil {
+			os.WriteFile(timer, []byte("1"), 0644)
+		}
+	}
+
+	file, err := serialOpenControl(portName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var ss serialStruct
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, file.Fd(), unix.TIOCGSERIAL, uintptr(unsafe.Pointer(&ss)))
+	if errno != 0 {
+		return os.NewSyscallError("TIOCGSERIAL", errno)
+	}
+
+	ss.flags |= serialAsyncLowLatency
+	_, _, errno = unix.Syscall(unix.SYS_IOCTL, file.Fd(), unix.TIOCSSERIAL, uintptr(unsafe.Pointer(&ss)))
+	if errno != 0 {
+		return os.NewSyscallError("TIOCSSERIAL", errno)
+	}
+
+	return nil
+}
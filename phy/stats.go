@@ -0,0 +1,48 @@
+package phy
+
+import "sync"
+
+// Stats contains counters describing the traffic seen by the PHY.
+type Stats struct {
+	// BytesRX is the number of bytes read from the port.
+	BytesRX uint64
+	// BytesTX is the number of bytes written to the port.
+	BytesTX uint64
+
+	// FramesRX is the number of frames received with a valid checksum.
+	FramesRX uint64
+	// FramesTX is the number of frames transmitted.
+	FramesTX uint64
+
+	// ChecksumErrors is the number of frames discarded due to an invalid checksum.
+	ChecksumErrors uint64
+	// FramingErrors is the number of frames discarded due to an invalid header.
+	FramingErrors uint64
+	// EscapeErrors is the number of times a frame start interrupted a frame in progress.
+	EscapeErrors uint64
+	// PartialFrames is the number of incomplete frames that were discarded.
+	PartialFrames uint64
+
+	// Collisions is the number of transmitted frames that were corrupted on the bus.
+	Collisions uint64
+}
+
+type statsCounter struct {
+	sync.Mutex
+	stats Stats
+}
+
+func (s *statsCounter) update(cb func(stats *Stats)) {
+	s.Lock()
+	defer s.Unlock()
+
+	cb(&s.stats)
+}
+
+// Stats returns a copy of the current traffic counters.
+func (b *PHY) Stats() Stats {
+	b.stats.Lock()
+	defer b.stats.Unlock()
+
+	return b.stats.stats
+}
@@ -0,0 +1,127 @@
+package phy
+
+import (
+	"time"
+)
+
+type txRequest struct {
+	isBreak       bool
+	breakDuration time.Duration
+
+	addrSource uint8
+	addrDest   uint8
+	payload    []byte
+	done       func(err error)
+}
+
+func (b *PHY) txQueueStart() {
+	b.txQueueOnce.Do(func() {
+		b.txQueue = make(chan (txRequest), 32)
+
+		go func() {
+			for {
+				select {
+				case req := <-b.txQueue:
+					var err error
+					if req.isBreak {
+						err = b.SendBreak(req.breakDuration)
+					} else {
+						err = b.TXSendPacket(req.addrSource, req.addrDest, req.payload)
+					}
+					if req.done != nil {
+						req.done(err)
+					}
+				case <-b.closed.Chan():
+					return
+				}
+			}
+		}()
+	})
+}
+
+// TXSendPacketAsync queues a packet for transmission and returns immediately. Queued packets
+// are sent in order. The optional done callback is called with the result once the packet has
+// been written.
+func (b *PHY) TXSendPacketAsync(addrSource uint8, addrDest uint8, payload []byte, done func(err error)) error {
+	if b.closed.IsClosed() {
+		return ErrorPortClosed
+	}
+
+	return b.txQueuePut(txRequest{
+		addrSource: addrSource,
+		addrDest:   addrDest,
+		payload:    append([]byte(nil), payload...),
+		done:       done,
+	})
+}
+
+// SendBreakAsync queues a break and returns immediately. The break is sent in order with
+// packets queued by TXSendPacketAsync. The optional done callback is called with the result
+// once the break has completed.
+func (b *PHY) SendBreakAsync(d time.Duration, done func(err error)) error {
+	if b.closed.IsClosed() {
+		return ErrorPortClosed
+	}
+
+	return b.txQueuePut(txRequest{
+		isBreak:       true,
+		breakDuration: d,
+		done:          done,
+	})
+}
+
+func (b *PHY) txQueuePut(req txRequest) error {
+	b.txQueueStart()
+
+	select {
+	case b.txQueue <- req:
+		return nil
+	case <-b.closed.Chan():
+		return ErrorPortClosed
+	}
+}
+
+// SendBreak calls TXSendBreak while no packet is being transmitted. It does nothing if
+// TXSendBreak is not set.
+func (b *PHY) SendBreak(d time.Duration) error {
+	if b.TXSendBreak == nil {
+		return nil
+	}
+
+	b.txMutex.Lock()
+	defer b.txMutex.Unlock()
+
+	if b.Metrics != nil {
+		b.Metrics.OnBreak(d)
+	}
+
+	return b.TXSendBreak(d)
+}
+
+// TXSendPresence emits the presence signal that a device sends when it is connected to the
+// bus. Any byte received outside of a frame is seen as presence by the controller, so a single
+// zero byte is used. This allows the PHY to act as the device side of the link.
+func (b *PHY) TXSendPresence() error {
+	b.txMutex.Lock()
+	defer b.txMutex.Unlock()
+
+	port, _ := b.port()
+	n, err := port.Write([]byte{0})
+	b.stats.update(func(stats *Stats) {
+		stats.BytesTX += uint64(n)
+	})
+	return err
+}
+
+// TXSendRaw writes the given bytes to the line without any encoding. It can be used to replay
+// captured frames or to send intentionally malformed traffic.
+func (b *PHY) TXSendRaw(raw []byte) error {
+	b.txMutex.Lock()
+	defer b.txMutex.Unlock()
+
+	if b.Capture != nil {
+		b.Capture.WriteFrame(time.Now(), DirectionTX, raw)
+	}
+
+	return b.txWrite(raw)
+}
@@ -0,0 +1,74 @@
+package phy
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+type udpRemote struct {
+	sync.Mutex
+	addr net.Addr
+}
+
+func (u *udpRemote) get() net.Addr {
+	u.Lock()
+	defer u.Unlock()
+
+	return u.addr
+}
+
+func (u *udpRemote) set(addr net.Addr) {
+	u.Lock()
+	defer u.Unlock()
+
+	u.addr = addr
+}
+
+// NewUDP sets the PHY up for a bridge that relays the line over UDP. Every datagram carries
+// the raw bytes of one frame, exactly as they appear on the line. An empty datagram asks the
+// bridge to send a break. Datagrams are sent to remote. When remote is nil they are sent to
+// the address the last datagram was received from.
+func NewUDP(conn net.PacketConn, remote net.Addr) *PHY {
+	dest := &udpRemote{addr: remote}
+	fixed := remote != nil
+
+	write := func(p []byte) error {
+		addr := dest.get()
+		if addr == nil {
+			/* Nobody to talk to yet */
+			return nil
+		}
+
+		_, err := conn.WriteTo(p, addr)
+		return err
+	}
+
+	port := newMessagePort(0, write, conn.Close)
+
+	go func() {
+		defer port.Close()
+
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if !fixed {
+				dest.set(addr)
+			}
+			if n > 0 {
+				port.deliver(buf[:n])
+			}
+		}
+	}()
+
+	return &PHY{
+		Port: port,
+		TXSendBreak: func(d time.Duration) error {
+			return write(nil)
+		},
+	}
+}
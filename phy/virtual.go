@@ -0,0 +1,38 @@
+package phy
+
+import (
+	"io"
+)
+
+type pipePort struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p *pipePort) Read(buf []byte) (int, error) {
+	return p.r.Read(buf)
+}
+
+func (p *pipePort) Write(buf []byte) (int, error) {
+	return p.w.Write(buf)
+}
+
+func (p *pipePort) Close() error {
+	p.w.Close()
+	return p.r.Close()
+}
+
+func pipePair() (io.ReadWriteCloser, io.ReadWriteCloser) {
+	aR, bW := io.Pipe()
+	bR, aW := io.Pipe()
+
+	return &pipePort{r: aR, w: aW}, &pipePort{r: bR, w: bW}
+}
+
+// NewVirtualPair creates a PHY that is connected to a virtual line, which is intended for
+// tests that run without hardware. The returned port is the other end of the line and can
+// be used to simulate devices. On Linux a pseudo-terminal pair is used, elsewhere the line
+// is made of pipes.
+func NewVirtualPair() (*PHY, io.ReadWriteCloser, error) {
+	return virtualPair()
+}
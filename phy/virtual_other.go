@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package phy
+
+import (
+	"io"
+)
+
+func virtualPair() (*PHY, io.ReadWriteCloser, error) {
+	port, device := pipePair()
+
+	return &PHY{
+		Port: port,
+	}, device, nil
+}